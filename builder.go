@@ -0,0 +1,100 @@
+package argmap
+
+// CommandBuilder allows to define a command and its arguments by chaining method calls, as an
+// alternative to the struct-based functions. The first error stops the chain and is returned
+// by Build:
+//  err := parser.Command("print").Help("prints something").
+//      Bool("v", "verbose").Sub("file").Positional("path", true).Build()
+type CommandBuilder struct {
+	cmd    *Command
+	parent *CommandBuilder
+	err    *error
+}
+
+// Command inserts a new command in the parser and returns a builder for it
+func (p *ArgsParser) Command(name string) *CommandBuilder {
+	var err error
+	b := &CommandBuilder{err: &err}
+	b.cmd, err = p.NewCommand(CommandParams{Name: name})
+	return b
+}
+
+// add runs the insertion function unless a previous error occurred
+func (b *CommandBuilder) add(insert func(*Command) error) *CommandBuilder {
+	if *b.err == nil {
+		*b.err = insert(b.cmd)
+	}
+	return b
+}
+
+// Help sets the help message of the command
+func (b *CommandBuilder) Help(help string) *CommandBuilder {
+	return b.add(func(c *Command) error {
+		c.Help = help
+		return nil
+	})
+}
+
+// String inserts a StringFlag with a single value (short or name may be empty)
+func (b *CommandBuilder) String(short, name string) *CommandBuilder {
+	return b.add(func(c *Command) error {
+		return c.NewStringFlag(StringFlag{Name: name, Short: short})
+	})
+}
+
+// Int inserts an IntFlag with a single value (short or name may be empty)
+func (b *CommandBuilder) Int(short, name string) *CommandBuilder {
+	return b.add(func(c *Command) error {
+		return c.NewIntFlag(IntFlag{Name: name, Short: short})
+	})
+}
+
+// List inserts a ListFlag (short or name may be empty)
+func (b *CommandBuilder) List(short, name string) *CommandBuilder {
+	return b.add(func(c *Command) error {
+		return c.NewListFlag(ListFlag{Name: name, Short: short})
+	})
+}
+
+// Bool inserts a BoolFlag (short or name may be empty)
+func (b *CommandBuilder) Bool(short, name string) *CommandBuilder {
+	return b.add(func(c *Command) error {
+		return c.NewBoolFlag(BoolFlag{Name: name, Short: short})
+	})
+}
+
+// Positional inserts a PositionalArg
+func (b *CommandBuilder) Positional(name string, required bool) *CommandBuilder {
+	return b.add(func(c *Command) error {
+		return c.NewPositionalArg(PositionalArg{Name: name, Required: required})
+	})
+}
+
+// Sub inserts a subcommand and returns a builder for it. Use Up to go back to this command
+func (b *CommandBuilder) Sub(name string) *CommandBuilder {
+	sb := &CommandBuilder{parent: b, err: b.err}
+	b.add(func(c *Command) error {
+		var err error
+		sb.cmd, err = c.NewSubcommand(CommandParams{Name: name})
+		return err
+	})
+	return sb
+}
+
+// Up returns the builder of the parent command (the same builder for top-level commands)
+func (b *CommandBuilder) Up() *CommandBuilder {
+	if b.parent == nil {
+		return b
+	}
+	return b.parent
+}
+
+// Get returns the command being built (nil if it couldn't be inserted)
+func (b *CommandBuilder) Get() *Command {
+	return b.cmd
+}
+
+// Build returns the first error occurred along the chain, if any
+func (b *CommandBuilder) Build() error {
+	return *b.err
+}
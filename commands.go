@@ -9,18 +9,34 @@ import (
 // CommandHelpGenerator type used to allow customizable help for commands
 type CommandHelpGenerator func(*Command) string
 
+// CommandHandler type used to run a command with its own argument map (see Execute)
+type CommandHandler func(map[string]interface{}) error
+
 // Command is both a type of argument and a parser of what comes after it
 type Command struct {
-	name     string
-	Help     string
-	argsList []Argument
-	helpGen  CommandHelpGenerator
+	name        string
+	aliases     []string
+	Help        string
+	argsList    []Argument
+	index       *argsIndex
+	helpGen     CommandHelpGenerator
+	handler     CommandHandler
+	requires    []requirement
+	required    []string
+	deprecated  string
+	helpWidth   int
+	subRequired bool
+	captureRest string
 }
 
 // CommandParams used for commands initialization
+//  Deprecated    if not empty, the command still works but a warning with this message is collected when invoked
+//  Aliases       other names invoking the command (e.g. "rm" for "remove"), stored in the map with Name
 type CommandParams struct {
-	Name string
-	Help string
+	Name       string
+	Help       string
+	Deprecated string
+	Aliases    []string
 }
 
 // GetID returns the identifier of the command
@@ -28,14 +44,24 @@ func (c Command) GetID() string {
 	return c.name
 }
 
-// Represent returns the name of the command
+// Represent returns the name of the command followed by its aliases
 func (c Command) Represent() []string {
-	return []string{c.name}
+	return append([]string{c.name}, c.aliases...)
 }
 
 // GetHelpStrings returns the two hand sides of the help message
+//  Example:  ["remove, rm, del", "this is an example of help message"]
 func (c Command) GetHelpStrings() []string {
-	return []string{c.name, c.Help}
+	leftHand := strings.Join(c.Represent(), ", ")
+	if c.deprecated != "" {
+		return []string{leftHand, c.Help + " (deprecated)"}
+	}
+	return []string{leftHand, c.Help}
+}
+
+// Arity returns 0 since the command parses the following arguments by itself
+func (c Command) Arity() int {
+	return 0
 }
 
 // Defines the priority of the argument for sorting (also used to determine the argument type)
@@ -56,16 +82,66 @@ func (c *Command) SetHelpGenerator(h CommandHelpGenerator) {
 	c.helpGen = h
 }
 
+// AddRequires makes the flag depend on other flags of the command (see ArgsParser.AddRequires)
+func (c *Command) AddRequires(flag string, deps ...string) error {
+	reqs, err := newRequirements(c.argsList, flag, deps)
+	if err != nil {
+		return err
+	}
+	c.requires = append(c.requires, reqs...)
+	return nil
+}
+
+// Require declares arguments of the command which must be present in its parsed map (see
+// ArgsParser.Require)
+func (c *Command) Require(keys ...string) error {
+	for _, key := range keys {
+		if findArg(c.argsList, key) == nil {
+			return fmt.Errorf("Error: identifier '%s' not found", key)
+		}
+	}
+	c.required = append(c.required, keys...)
+	return nil
+}
+
+// SetCaptureRest makes the command store the tokens following its positionals verbatim under
+// the given key (see ArgsParser.SetCaptureRest)
+func (c *Command) SetCaptureRest(key string) error {
+	if findArg(c.argsList, key) != nil {
+		return fmt.Errorf("Error: identifier '%s' already exists", key)
+	}
+	c.captureRest = key
+	return nil
+}
+
+// SetHandler accepts a function to be run by Execute when this is the deepest invoked command
+func (c *Command) SetHandler(h CommandHandler) {
+	c.handler = h
+}
+
+// SetSubcommandRequired makes parsing fail when the command is invoked without any of its
+// subcommands (the help flag is still accepted)
+func (c *Command) SetSubcommandRequired(b bool) {
+	c.subRequired = b
+}
+
 // SetHelpFlagMessage accepts a string to be used in the program help with that HelpFlag
 func (c *Command) SetHelpFlagMessage(m string) {
 	for i, a := range c.argsList {
-		if a.getOrder() == orderHelpFlag {
-			c.argsList[i] = HelpFlag{Help: m}
+		if f, ok := a.(HelpFlag); ok {
+			f.Help = m
+			c.argsList[i] = f
 			return
 		}
 	}
 }
 
+// SetHelpFlagReps replaces the representations of the command help flag (see
+// ArgsParser.SetHelpFlagReps)
+func (c *Command) SetHelpFlagReps(short, long string) error {
+	return setHelpFlagReps(&c.argsList, c.index, short, long)
+}
+
 // SortArgsList sorts the list of arguments according to their type.
 func (c *Command) SortArgsList() {
 	sort.Slice(c.argsList, func(i, j int) bool {
@@ -76,44 +152,8 @@ func (c *Command) SortArgsList() {
 // DefaultCommandHelp produces a part of the help message for the command to be printed by the ArgsParser
 func DefaultCommandHelp(c *Command) string {
 	c.SortArgsList()
-	length := len(c.argsList)
-	argsHelp := make([][]string, length)
-
-	maxLeftLen := 0
-	subcommandsIndex := length
-	for i := 0; i < length; i++ {
-		argsHelp[i] = c.argsList[i].GetHelpStrings()
-		if len(argsHelp[i][0]) > maxLeftLen {
-			maxLeftLen = len(argsHelp[i][0])
-		}
-
-		if subcommandsIndex == length && c.argsList[i].getOrder() == orderCommand {
-			subcommandsIndex = i
-		}
-	}
-
-	if maxLeftLen > 40 {
-		maxLeftLen = 40
-	}
-
-	help := fmt.Sprintf("    %s   %s\n\nArguments:\n", c.name, c.Help)
-	for i := 0; i < length; i++ {
-		if i == subcommandsIndex {
-			help += "\nSubcommands:\n"
-		}
-
-		argStr := argsHelp[i][0]
-		for len(argStr) <= maxLeftLen {
-			argStr += " "
-		}
-		help += fmt.Sprintf("    %s %s\n", argStr, argsHelp[i][1])
-
-		if i == length-1 && subcommandsIndex < length {
-			help += "Type -h or --help after a command for more details\n"
-		}
-	}
-
-	return help
+	help := fmt.Sprintf("    %s   %s\n\n", c.name, c.Help)
+	return help + argumentsHelp(visibleArgs(c.argsList), "    ", "Subcommands", c.helpWidth)
 }
 
 // GetArgsList returns a copy of the argument list to be used for the production of custom helps
@@ -123,6 +163,34 @@ func (c *Command) GetArgsList() []Argument {
 	return arr
 }
 
+// clone returns a deep copy of the command and its subcommands
+func (c *Command) clone() *Command {
+	clone := *c
+	clone.aliases = append([]string{}, c.aliases...)
+	clone.argsList = cloneArgs(c.argsList)
+	clone.index = c.index.clone()
+	clone.requires = append([]requirement{}, c.requires...)
+	clone.required = append([]string{}, c.required...)
+	return &clone
+}
+
+// FlagsByType returns the arguments of the command separated by type (see ArgsByType)
+func (c *Command) FlagsByType() ArgsByType {
+	c.SortArgsList()
+	return groupArgs(c.argsList)
+}
+
+// SubcommandNames returns the names of all the subcommands of the command in alphabetical order
+func (c *Command) SubcommandNames() []string {
+	return commandNames(c.argsList)
+}
+
+// GetSubcommand returns the subcommand registered with the given name or alias, and false if
+// there is no such subcommand
+func (c *Command) GetSubcommand(name string) (*Command, bool) {
+	return findCommand(c.argsList, name)
+}
+
 /***************************************************************/
 
 // NewStringFlag checks the fields for consistency and inserts the new flag
@@ -131,10 +199,15 @@ func (c *Command) NewStringFlag(f StringFlag) error {
 		return fmt.Errorf("Error: at least one identifier must be specified")
 	}
 
+	if f.Variadic && (f.NArgs > 1 || f.AllowDashValue) {
+		return fmt.Errorf("Error: a variadic flag can't have NArgs or AllowDashValue")
+	}
+
 	if f.NArgs < 1 {
 		f.NArgs = 1
 	}
 
+	f.named = len(f.Vars)
 	if len(f.Vars) < f.NArgs {
 		for len(f.Vars) < f.NArgs {
 			f.Vars = append(f.Vars, "value")
@@ -143,13 +216,67 @@ func (c *Command) NewStringFlag(f StringFlag) error {
 		return fmt.Errorf("Error: too many value names specified (expected %d, got %d)", f.NArgs, len(f.Vars))
 	}
 
-	err := checkIdentifiers(&c.argsList, f)
-	if err != nil {
-		return err
+	return c.index.insert(&c.argsList, f)
+}
+
+// NewChoiceFlag checks the fields for consistency and inserts the new flag
+func (c *Command) NewChoiceFlag(f ChoiceFlag) error {
+	if f.Name == "" && f.Short == "" {
+		return fmt.Errorf("Error: at least one identifier must be specified")
+	}
+	if len(f.Choices) == 0 {
+		return fmt.Errorf("Error: at least one choice must be specified")
 	}
 
-	c.argsList = append(c.argsList, f)
-	return nil
+	return c.index.insert(&c.argsList, f)
+}
+
+// NewIntFlag checks the fields for consistency and inserts the new flag
+func (c *Command) NewIntFlag(f IntFlag) error {
+	if f.Name == "" && f.Short == "" {
+		return fmt.Errorf("Error: at least one identifier must be specified")
+	}
+
+	if f.NArgs < 1 {
+		f.NArgs = 1
+	}
+	if f.Default != nil && len(f.Default) != f.NArgs {
+		return fmt.Errorf("Error: wrong number of default values (expected %d, got %d)", f.NArgs, len(f.Default))
+	}
+
+	return c.index.insert(&c.argsList, f)
+}
+
+// NewFloatFlag checks the fields for consistency and inserts the new flag
+func (c *Command) NewFloatFlag(f FloatFlag) error {
+	if f.Name == "" && f.Short == "" {
+		return fmt.Errorf("Error: at least one identifier must be specified")
+	}
+
+	if f.NArgs < 1 {
+		f.NArgs = 1
+	}
+	if f.Default != nil && len(f.Default) != f.NArgs {
+		return fmt.Errorf("Error: wrong number of default values (expected %d, got %d)", f.NArgs, len(f.Default))
+	}
+
+	return c.index.insert(&c.argsList, f)
+}
+
+// NewDurationFlag checks the fields for consistency and inserts the new flag
+func (c *Command) NewDurationFlag(f DurationFlag) error {
+	if f.Name == "" && f.Short == "" {
+		return fmt.Errorf("Error: at least one identifier must be specified")
+	}
+
+	if f.NArgs < 1 {
+		f.NArgs = 1
+	}
+	if f.Default != nil && len(f.Default) != f.NArgs {
+		return fmt.Errorf("Error: wrong number of default values (expected %d, got %d)", f.NArgs, len(f.Default))
+	}
+
+	return c.index.insert(&c.argsList, f)
 }
 
 // NewListFlag checks the fields for consistency and inserts the new flag
@@ -160,14 +287,11 @@ func (c *Command) NewListFlag(f ListFlag) error {
 	if f.Var == "" {
 		f.Var = "value"
 	}
-
-	err := checkIdentifiers(&c.argsList, f)
-	if err != nil {
-		return err
+	if f.Max < 0 {
+		return fmt.Errorf("Error: the maximum number of values must not be negative")
 	}
 
-	c.argsList = append(c.argsList, f)
-	return nil
+	return c.index.insert(&c.argsList, f)
 }
 
 // NewBoolFlag checks the flag representations and inserts the new flag
@@ -175,14 +299,20 @@ func (c *Command) NewBoolFlag(f BoolFlag) error {
 	if f.Name == "" && f.Short == "" {
 		return fmt.Errorf("Error: at least one identifier must be specified")
 	}
+	if f.Negatable && f.Name == "" {
+		return fmt.Errorf("Error: a negatable flag must have a Name")
+	}
 
-	err := checkIdentifiers(&c.argsList, f)
-	if err != nil {
-		return err
+	return c.index.insert(&c.argsList, f)
+}
+
+// NewCountFlag checks the fields for consistency and inserts the new flag
+func (c *Command) NewCountFlag(f CountFlag) error {
+	if f.Name == "" && f.Short == "" {
+		return fmt.Errorf("Error: at least one identifier must be specified")
 	}
 
-	c.argsList = append(c.argsList, f)
-	return nil
+	return c.index.insert(&c.argsList, f)
 }
 
 // NewPositionalArg checks the argument identifier and inserts it
@@ -191,13 +321,10 @@ func (c *Command) NewPositionalArg(a PositionalArg) error {
 		return fmt.Errorf("Error: unspecified argument name")
 	}
 
-	err := checkIdentifiers(&c.argsList, a)
-	if err != nil {
-		return err
-	}
-
-	c.argsList = append(c.argsList, a)
-	return nil
+	a.inserted = c.index.next()
+	return c.index.insert(&c.argsList, a, func(argsList []Argument) error {
+		return checkVariadic(argsList, a)
+	})
 }
 
 // NewSubcommand checks the argument identifier and inserts it
@@ -207,34 +334,75 @@ func (c *Command) NewSubcommand(param CommandParams) (*Command, error) {
 	}
 
 	sc := &Command{
-		name:     param.Name,
-		Help:     param.Help,
-		argsList: []Argument{HelpFlag{"shows command help and exits"}},
-		helpGen:  DefaultCommandHelp,
+		name:       param.Name,
+		Help:       param.Help,
+		argsList:   []Argument{HelpFlag{Help: "shows command help and exits"}},
+		index:      &argsIndex{},
+		helpGen:    DefaultCommandHelp,
+		deprecated: param.Deprecated,
+		aliases:    append([]string{}, param.Aliases...),
+		helpWidth:  c.helpWidth,
 	}
 
-	err := checkIdentifiers(&c.argsList, sc)
-	if err != nil {
+	if err := c.index.insert(&c.argsList, sc); err != nil {
 		return nil, err
 	}
-
-	c.argsList = append(c.argsList, sc)
 	return sc, nil
 }
 
 /******************************************************************/
 
-func (c *Command) parseArgs(args []string) (map[string]interface{}, error) {
+func (c *Command) parseArgs(args []string, cfg *parseConfig) (map[string]interface{}, error) {
+	cfg.cmdPath = append(cfg.cmdPath, c.name)
+	defer func() { cfg.cmdPath = cfg.cmdPath[:len(cfg.cmdPath)-1] }()
+	capture := cfg.captureRest
+	cfg.captureRest = c.captureRest
+	defer func() { cfg.captureRest = capture }()
+
 	c.SortArgsList()
-	argsMap, err := parseArgs(args, c.argsList)
+	argsMap, err := parseArgs(args, c.argsList, cfg, nil)
+	if err == nil && !GetBool(argsMap, "help") {
+		err = checkRequires(argsMap, c.argsList, c.requires)
+		if err == nil {
+			err = checkRequired(argsMap, c.required)
+		}
+		if err == nil && c.subRequired && invokedCommand(c.argsList, argsMap) == nil {
+			err = errMissingSubcommand
+		}
+	}
 	if err != nil {
-		placeholder := "[*]"
-		errorString := err.Error()
-		if strings.Contains(errorString, placeholder) {
-			errorString = strings.Replace(errorString, placeholder, fmt.Sprintf("%s%s ", placeholder, c.name), 1)
-			return nil, fmt.Errorf(errorString)
+		if cmdErr, ok := err.(CommandError); ok {
+			cmdErr.Path = append([]string{c.name}, cmdErr.Path...)
+			return nil, cmdErr
 		}
-		return nil, fmt.Errorf("%s for command '%s%s'", errorString, placeholder, c.name)
+		return nil, CommandError{Path: []string{c.name}, Err: err}
 	}
 	return argsMap, nil
 }
+
+// GetCommandMap returns the name and the argument map of the invoked subcommand, as the
+// GetCommandMap function does, but only the subcommands of the command are considered
+func (c *Command) GetCommandMap(cmdMap map[string]interface{}) (string, map[string]interface{}, error) {
+	return commandMap(c.argsList, cmdMap)
+}
+
+// commandMap returns the name and the argument map of the command of the list which is
+// present in the map
+func commandMap(argsList []Argument, aMap map[string]interface{}) (string, map[string]interface{}, error) {
+	if c := invokedCommand(argsList, aMap); c != nil {
+		if cmdMap, ok := GetSubMap(aMap, c.GetID()); ok {
+			return c.GetID(), cmdMap, nil
+		}
+	}
+	return "", nil, fmt.Errorf("Error: no command found in map")
+}
+
+// invokedCommand returns the command of the list which is present in the map (nil if none)
+func invokedCommand(argsList []Argument, aMap map[string]interface{}) *Command {
+	for _, c := range commandsOf(argsList) {
+		if IsPresent(aMap, c.GetID()) {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+package argmap
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// completionLevel gathers the arguments available after the program name and a command path
+type completionLevel struct {
+	path     []string
+	argsList []Argument
+}
+
+// completionLevels walks the command tree, returning the program level followed by the
+// levels of its commands and subcommands (sorted by name)
+func completionLevels(argsList []Argument, path []string) []completionLevel {
+	levels := []completionLevel{{path, argsList}}
+	for _, c := range commandsOf(argsList) {
+		subPath := append(append([]string{}, path...), c.GetID())
+		levels = append(levels, completionLevels(c.argsList, subPath)...)
+	}
+	return levels
+}
+
+// completionWords returns the flag representations and the command names of a level, along
+// with the conditions on the index of the positional being completed ($pos) under which file
+// paths are offered, one for each positional with FileCompletion (e.g. "pos == 0")
+func completionWords(argsList []Argument) ([]string, []string) {
+	words := []string{}
+	files := []string{}
+	position := 0
+	for _, a := range argsList {
+		if pos, ok := a.(PositionalArg); ok {
+			if pos.FileCompletion && pos.Variadic {
+				files = append(files, fmt.Sprintf("pos >= %d", position))
+			} else if pos.FileCompletion {
+				files = append(files, fmt.Sprintf("pos == %d", position))
+			}
+			position++
+			continue
+		}
+		if isHidden(a) {
+			continue
+		}
+		words = append(words, a.Represent()...)
+	}
+	return words, files
+}
+
+var nonIdentifier = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// completionFunc returns the name of the shell function completing the program
+func (p *ArgsParser) completionFunc() string {
+	return "_" + nonIdentifier.ReplaceAllString(p.Name, "_") + "_completion"
+}
+
+// GenerateBashCompletion produces a bash completion script for the program, offering the
+// flags and the commands available at each level of the command tree, the choices of the
+// ChoiceFlags and the file paths at the positions of the positionals with FileCompletion.
+// The positionals are counted skipping the values of the flags taking a fixed number of them,
+// while the values of ListFlags and variadic flags are counted as positionals.
+// The parser Name is expected to be the name of the executable
+func (p *ArgsParser) GenerateBashCompletion() string {
+	p.SortArgsList()
+	fn := p.completionFunc()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# bash completion for %s\n", p.Name)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("    local cur prev path pos opts files i\n")
+	b.WriteString("    cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	b.WriteString("    prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	b.WriteString("    path=\"\"\n")
+	b.WriteString("    pos=0\n\n")
+
+	// The command path and the index of the positional are rebuilt from the words typed so
+	// far, skipping the values of the flags
+	b.WriteString("    for ((i=1; i<COMP_CWORD; i++)); do\n")
+	b.WriteString("        case \"$path ${COMP_WORDS[i]}\" in\n")
+	levels := completionLevels(p.argsList, nil)
+	for _, l := range levels[1:] {
+		parent := bashPath(l.path[:len(l.path)-1])
+		cmd := findArg(p.commandArgs(l.path[:len(l.path)-1]), l.path[len(l.path)-1])
+		patterns := []string{}
+		for _, r := range cmd.Represent() {
+			patterns = append(patterns, fmt.Sprintf("\"%s %s\"", parent, r))
+		}
+		fmt.Fprintf(&b, "            %s) path=\"%s\"; pos=0 ;;\n", strings.Join(patterns, "|"), bashPath(l.path))
+	}
+	for _, l := range levels {
+		for _, a := range l.argsList {
+			if _, ok := a.(PositionalArg); ok || a.getOrder() == orderCommand || a.Arity() <= 0 {
+				continue
+			}
+			patterns := []string{}
+			for _, r := range a.Represent() {
+				patterns = append(patterns, fmt.Sprintf("\"%s %s\"", bashPath(l.path), r))
+			}
+			fmt.Fprintf(&b, "            %s) i=$((i+%d)) ;;\n", strings.Join(patterns, "|"), a.Arity())
+		}
+	}
+	b.WriteString("            *\" -\"*) ;;\n")
+	b.WriteString("            *) pos=$((pos+1)) ;;\n")
+	b.WriteString("        esac\n")
+	b.WriteString("    done\n")
+	// The word being completed is a value of the last flag
+	b.WriteString("    if ((i > COMP_CWORD)); then\n")
+	b.WriteString("        pos=-1\n")
+	b.WriteString("    fi\n\n")
+
+	b.WriteString("    files=0\n")
+	b.WriteString("    case \"$path\" in\n")
+	for _, l := range levels {
+		fmt.Fprintf(&b, "        \"%s\")\n", bashPath(l.path))
+		choices := choiceFlags(l.argsList)
+		if len(choices) > 0 {
+			b.WriteString("            case \"$prev\" in\n")
+			for _, f := range choices {
+				fmt.Fprintf(&b, "                %s) COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") ); return 0 ;;\n", strings.Join(f.Represent(), "|"), strings.Join(f.Choices, " "))
+			}
+			b.WriteString("            esac\n")
+		}
+
+		words, files := completionWords(l.argsList)
+		fmt.Fprintf(&b, "            opts=\"%s\"\n", strings.Join(words, " "))
+		if len(files) > 0 {
+			fmt.Fprintf(&b, "            if ((%s)); then\n", strings.Join(files, " || "))
+			b.WriteString("                files=1\n")
+			b.WriteString("            fi\n")
+		}
+		b.WriteString("            ;;\n")
+	}
+	b.WriteString("    esac\n\n")
+
+	b.WriteString("    COMPREPLY=( $(compgen -W \"$opts\" -- \"$cur\") )\n")
+	b.WriteString("    if [[ $files == 1 && $cur != -* ]]; then\n")
+	b.WriteString("        COMPREPLY+=( $(compgen -f -- \"$cur\") )\n")
+	b.WriteString("    fi\n")
+	b.WriteString("    return 0\n")
+	b.WriteString("}\n")
+	fmt.Fprintf(&b, "complete -F %s %s\n", fn, p.Name)
+	return b.String()
+}
+
+// InstallCompletion writes the bash completion script of the program to the writer (e.g. a
+// file in /etc/bash_completion.d)
+func (p *ArgsParser) InstallCompletion(w io.Writer) error {
+	_, err := io.WriteString(w, p.GenerateBashCompletion())
+	return err
+}
+
+// commandArgs returns the arguments of the command at the end of the path
+func (p *ArgsParser) commandArgs(path []string) []Argument {
+	argsList := p.argsList
+	for _, name := range path {
+		argsList = findArg(argsList, name).(*Command).argsList
+	}
+	return argsList
+}
+
+// bashPath renders a command path as matched by the completion script (e.g. " print file")
+func bashPath(path []string) string {
+	if len(path) == 0 {
+		return ""
+	}
+	return " " + strings.Join(path, " ")
+}
+
+// choiceFlags returns the ChoiceFlags of the list
+func choiceFlags(argsList []Argument) []ChoiceFlag {
+	flags := []ChoiceFlag{}
+	for _, a := range argsList {
+		if f, ok := a.(ChoiceFlag); ok {
+			flags = append(flags, f)
+		}
+	}
+	return flags
+}
+
+// GenerateZshCompletion produces a zsh completion script for the program (to be placed in a
+// directory of $fpath as "_<name>"). Each level of the command tree gets its own function
+// offering the flags with their values, the choices of the ChoiceFlags, the commands and
+// the positionals. The parser Name is expected to be the name of the executable
+func (p *ArgsParser) GenerateZshCompletion() string {
+	p.SortArgsList()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#compdef %s\n", p.Name)
+	for _, l := range completionLevels(p.argsList, nil) {
+		b.WriteString("\n")
+		b.WriteString(p.zshFunction(l))
+	}
+	fmt.Fprintf(&b, "\n%s \"$@\"\n", p.zshFuncName(nil))
+	return b.String()
+}
+
+// zshFuncName returns the name of the function completing a level (e.g. "_prog_print_file")
+func (p *ArgsParser) zshFuncName(path []string) string {
+	name := "_" + p.Name
+	for _, c := range path {
+		name += "_" + c
+	}
+	return nonIdentifier.ReplaceAllString(name, "_")
+}
+
+// zshFunction produces the completion function of a level
+func (p *ArgsParser) zshFunction(l completionLevel) string {
+	specs := []string{}
+	commands := commandsOf(l.argsList)
+	positionals := 0
+	for _, a := range l.argsList {
+		switch a.getOrder() {
+		case orderCommand:
+			continue
+		case orderPositionalReq, orderPositionalOpt:
+			// The commands take the place of the positionals
+			if len(commands) == 0 {
+				positionals++
+				specs = append(specs, zshPositionalSpec(a.(PositionalArg), positionals))
+			}
+		default:
+			if !isHidden(a) {
+				specs = append(specs, zshFlagSpec(a))
+			}
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s() {\n", p.zshFuncName(l.path))
+	if len(commands) > 0 {
+		b.WriteString("    local -a commands\n")
+		b.WriteString("    commands=(\n")
+		for _, c := range commands {
+			for _, r := range c.Represent() {
+				fmt.Fprintf(&b, "        '%s:%s'\n", r, zshEscape(c.Help))
+			}
+		}
+		b.WriteString("    )\n")
+		specs = append(specs, "'1: :->command'", "'*:: :->args'")
+	}
+
+	b.WriteString("    _arguments -C")
+	for _, s := range specs {
+		fmt.Fprintf(&b, " \\\n        %s", s)
+	}
+	b.WriteString("\n")
+
+	if len(commands) > 0 {
+		b.WriteString("\n    case $state in\n")
+		b.WriteString("        command) _describe 'command' commands ;;\n")
+		b.WriteString("        args)\n")
+		b.WriteString("            case $words[1] in\n")
+		for _, c := range commands {
+			subPath := append(append([]string{}, l.path...), c.GetID())
+			fmt.Fprintf(&b, "                %s) %s ;;\n", strings.Join(c.Represent(), "|"), p.zshFuncName(subPath))
+		}
+		b.WriteString("            esac\n")
+		b.WriteString("            ;;\n")
+		b.WriteString("    esac\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// zshFlagSpec produces the _arguments specification of a flag, taking the names of its values
+// from the left-hand side of its help (e.g. "-n, --name your_name")
+func zshFlagSpec(a Argument) string {
+	reprs := a.Represent()
+	help := a.GetHelpStrings()
+	metavars := strings.Fields(strings.TrimPrefix(help[0], strings.Join(reprs, ", ")))
+
+	// Repeatable flags can't exclude their other representations
+	exclusion := fmt.Sprintf("(%s)", strings.Join(reprs, " "))
+	if a.getOrder() == orderCountFlag {
+		exclusion = "*"
+	}
+
+	spec := "'" + reprs[0]
+	if len(reprs) > 1 {
+		spec = fmt.Sprintf("'%s'{%s}'", exclusion, strings.Join(reprs, ","))
+	} else if a.getOrder() == orderCountFlag {
+		spec = "'*" + reprs[0]
+	}
+	spec += "[" + zshEscape(help[1]) + "]"
+
+	switch f := a.(type) {
+	case ChoiceFlag:
+		spec += fmt.Sprintf(":%s:(%s)", f.GetID(), strings.Join(f.Choices, " "))
+	case ListFlag:
+		spec += fmt.Sprintf(":%s:", f.GetID())
+	default:
+		for _, m := range metavars {
+			if m != "..." {
+				spec += fmt.Sprintf(":%s:", m)
+			}
+		}
+	}
+	return spec + "'"
+}
+
+// zshPositionalSpec produces the _arguments specification of the positional at the given
+// position (starting from 1)
+func zshPositionalSpec(a PositionalArg, position int) string {
+	action := " "
+	if a.FileCompletion {
+		action = "_files"
+	}
+	if a.Variadic {
+		return fmt.Sprintf("'*:%s:%s'", a.Name, action)
+	}
+	return fmt.Sprintf("'%d:%s:%s'", position, a.Name, action)
+}
+
+// zshEscape makes a help message usable inside the single-quoted specifications
+func zshEscape(s string) string {
+	r := strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`)
+	return r.Replace(s)
+}
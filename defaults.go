@@ -0,0 +1,265 @@
+package argmap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadDefaults reads the default values of the program flags from a file (see LoadDefaultsFrom)
+func (p *ArgsParser) LoadDefaults(path string) error {
+	return p.LoadDefaultsContext(context.Background(), path)
+}
+
+// LoadDefaultsContext works like LoadDefaults, but stops reading the file and returns
+// ctx.Err() once the context is done
+func (p *ArgsParser) LoadDefaultsContext(ctx context.Context, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return p.LoadDefaultsFromContext(ctx, f)
+}
+
+// LoadDefaultsFrom reads the default values of the program flags, used when a flag is not
+// inserted by the user nor set in its environment variable. Precedence is: command line,
+// environment, loaded defaults and then the Default field of the flag.
+// The input can be a JSON object or a list of "key=value" lines (empty lines and lines
+// starting with "#" are skipped), where keys are the flag identifiers. In "key=value" lines,
+// the values of flags taking more than one value are separated by spaces. The values are
+// checked against the flag types, so the flags must be inserted before loading their defaults.
+// Unknown keys are ignored unless SetStrictDefaults is enabled. Commands are not covered
+func (p *ArgsParser) LoadDefaultsFrom(r io.Reader) error {
+	return p.LoadDefaultsFromContext(context.Background(), r)
+}
+
+// LoadDefaultsFromContext works like LoadDefaultsFrom, but stops reading and returns ctx.Err()
+// once the context is done. The context is also passed to the ValidateContext functions
+func (p *ArgsParser) LoadDefaultsFromContext(ctx context.Context, r io.Reader) error {
+	content, err := ioutil.ReadAll(contextReader{ctx, r})
+	if err != nil {
+		return err
+	}
+
+	var values map[string][]string
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")) {
+		values, err = readJSONDefaults(content)
+	} else {
+		values, err = readLineDefaults(content, p.argsList)
+	}
+	if err != nil {
+		return err
+	}
+
+	defaults := make(map[string][]string)
+	for key, v := range p.config.defaults {
+		defaults[key] = v
+	}
+	for key, v := range values {
+		a := findArg(p.argsList, key)
+		if a == nil {
+			if p.config.strictDefaults {
+				return fmt.Errorf("Error: unknown key '%s' in defaults", key)
+			}
+			continue
+		}
+		if _, err := convertDefault(ctx, a, v); err != nil {
+			return err
+		}
+		defaults[a.GetID()] = v
+	}
+
+	p.config.defaults = defaults
+	return nil
+}
+
+// SetStrictDefaults makes LoadDefaults fail on the keys which don't match any flag
+func (p *ArgsParser) SetStrictDefaults(b bool) {
+	p.config.strictDefaults = b
+}
+
+// readJSONDefaults reads the values of a JSON object: arrays hold the values of flags taking
+// more than one value, while numbers and booleans are kept as they are written
+func readJSONDefaults(content []byte) (map[string][]string, error) {
+	var object map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return nil, fmt.Errorf("Error: invalid JSON defaults: %s", err)
+	}
+
+	values := make(map[string][]string)
+	for key, v := range object {
+		if list, ok := v.([]interface{}); ok {
+			values[key] = []string{}
+			for _, item := range list {
+				values[key] = append(values[key], fmt.Sprint(item))
+			}
+		} else {
+			values[key] = []string{fmt.Sprint(v)}
+		}
+	}
+	return values, nil
+}
+
+// readLineDefaults reads the values of the "key=value" lines. A value is split by spaces only
+// if the flag takes more than one value
+func readLineDefaults(content []byte, argsList []Argument) (map[string][]string, error) {
+	values := make(map[string][]string)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Error: expected 'key=value' at line %d of defaults", line)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+		if a := findArg(argsList, key); a != nil && (a.Arity() > 1 || a.Arity() < 0) {
+			values[key] = strings.Fields(value)
+		} else {
+			values[key] = []string{value}
+		}
+	}
+	return values, scanner.Err()
+}
+
+// contextReader stops reading once its context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(b)
+}
+
+// convertDefault converts the loaded values to the type stored in the map by the flag
+func convertDefault(ctx context.Context, a Argument, values []string) (interface{}, error) {
+	id := a.GetID()
+	expectValues := func(n int) error {
+		if len(values) != n {
+			return fmt.Errorf("Error: expected %d values for '%s' in defaults, got %d", n, id, len(values))
+		}
+		return nil
+	}
+	invalid := func(v, kind string) error {
+		return fmt.Errorf("Error: default value '%s' for '%s' is not %s", v, id, kind)
+	}
+
+	switch f := a.(type) {
+	case StringFlag:
+		if err := expectValues(f.NArgs); err != nil && !f.Variadic {
+			return nil, err
+		}
+		converted := f.transform(append([]string{}, values...))
+		if err := f.validate(ctx, converted, argName(f)); err != nil {
+			return nil, err
+		}
+		return converted, nil
+	case ChoiceFlag:
+		if err := expectValues(1); err != nil {
+			return nil, err
+		}
+		if !contains(f.Choices, values[0]) {
+			return nil, fmt.Errorf("Error: invalid default value '%s' for '%s' (allowed: %s)", values[0], id, strings.Join(f.Choices, ", "))
+		}
+		return []string{values[0]}, nil
+	case IntFlag:
+		if err := expectValues(f.NArgs); err != nil {
+			return nil, err
+		}
+		ints := make([]int, len(values))
+		for i, v := range values {
+			var err error
+			if ints[i], err = strconv.Atoi(v); err != nil {
+				return nil, invalid(v, "an integer")
+			}
+		}
+		return ints, nil
+	case FloatFlag:
+		if err := expectValues(f.NArgs); err != nil {
+			return nil, err
+		}
+		floats := make([]float64, len(values))
+		for i, v := range values {
+			var err error
+			if floats[i], err = strconv.ParseFloat(v, 64); err != nil {
+				return nil, invalid(v, "a number")
+			}
+		}
+		return floats, nil
+	case DurationFlag:
+		if err := expectValues(f.NArgs); err != nil {
+			return nil, err
+		}
+		durations := make([]time.Duration, len(values))
+		for i, v := range values {
+			var err error
+			if durations[i], err = time.ParseDuration(v); err != nil {
+				return nil, invalid(v, "a valid duration")
+			}
+		}
+		return durations, nil
+	case ListFlag:
+		return append([]string{}, values...), nil
+	case BoolFlag:
+		if err := expectValues(1); err != nil {
+			return nil, err
+		}
+		b, err := parseBoolString(values[0])
+		if err != nil {
+			return nil, invalid(values[0], "a boolean")
+		}
+		return b, nil
+	case CountFlag:
+		if err := expectValues(1); err != nil {
+			return nil, err
+		}
+		count, err := strconv.Atoi(values[0])
+		if err != nil {
+			return nil, invalid(values[0], "an integer")
+		}
+		return count, nil
+	}
+	return nil, fmt.Errorf("Error: default value for '%s' can't be set since it's not a flag", id)
+}
+
+// applyLoadedDefaults fills the absent flags of the program with the loaded defaults
+func applyLoadedDefaults(argsMap map[string]interface{}, argsList []Argument, cfg *parseConfig) error {
+	if len(cfg.cmdPath) > 0 {
+		return nil
+	}
+
+	for _, a := range argsList {
+		values, ok := cfg.defaults[a.GetID()]
+		if !ok || IsPresent(argsMap, a.GetID()) {
+			continue
+		}
+		value, err := convertDefault(cfg.context(), a, values)
+		if err != nil {
+			return err
+		}
+		argsMap[a.GetID()] = value
+	}
+	return nil
+}
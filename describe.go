@@ -0,0 +1,140 @@
+package argmap
+
+// CLISpec describes the structure of the program as data, e.g. to generate documentation or
+// a user interface. It's produced by DescribeCLI and can be encoded as JSON
+type CLISpec struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Flags       []FlagSpec       `json:"flags,omitempty"`
+	Positionals []PositionalSpec `json:"positionals,omitempty"`
+	Commands    []CommandSpec    `json:"commands,omitempty"`
+}
+
+// FlagSpec describes a flag of the program or of a command
+//  Type    "string", "choice", "int", "float", "duration", "list", "bool", "count", "help" or "version"
+//  NArgs   number of values taken by the flag (-1 for a variable number)
+type FlagSpec struct {
+	Name       string   `json:"name,omitempty"`
+	Short      string   `json:"short,omitempty"`
+	Help       string   `json:"help,omitempty"`
+	Type       string   `json:"type"`
+	NArgs      int      `json:"nargs"`
+	Choices    []string `json:"choices,omitempty"`
+	Required   bool     `json:"required,omitempty"`
+	Hidden     bool     `json:"hidden,omitempty"`
+	Deprecated string   `json:"deprecated,omitempty"`
+	Group      string   `json:"group,omitempty"`
+	Example    string   `json:"example,omitempty"`
+}
+
+// PositionalSpec describes a positional argument of the program or of a command
+//  Kind    "string", "int", "float" or "bool"
+type PositionalSpec struct {
+	Name     string `json:"name"`
+	Help     string `json:"help,omitempty"`
+	Kind     string `json:"kind"`
+	Required bool   `json:"required,omitempty"`
+	Variadic bool   `json:"variadic,omitempty"`
+}
+
+// CommandSpec describes a command with its arguments and subcommands
+type CommandSpec struct {
+	Name               string           `json:"name"`
+	Aliases            []string         `json:"aliases,omitempty"`
+	Help               string           `json:"help,omitempty"`
+	Deprecated         string           `json:"deprecated,omitempty"`
+	SubcommandRequired bool             `json:"subcommandRequired,omitempty"`
+	Flags              []FlagSpec       `json:"flags,omitempty"`
+	Positionals        []PositionalSpec `json:"positionals,omitempty"`
+	Commands           []CommandSpec    `json:"commands,omitempty"`
+}
+
+// DescribeCLI walks the arguments and the command tree of the program, returning their
+// structure in the order of the help messages. Hidden flags are included and marked as such
+func (p *ArgsParser) DescribeCLI() CLISpec {
+	p.SortArgsList()
+	spec := CLISpec{Name: p.Name, Description: p.Description}
+	spec.Flags, spec.Positionals, spec.Commands = describeArgs(p.argsList)
+	return spec
+}
+
+// describeArgs separates the descriptions of the flags, the positionals and the commands
+func describeArgs(argsList []Argument) ([]FlagSpec, []PositionalSpec, []CommandSpec) {
+	flags := []FlagSpec{}
+	positionals := []PositionalSpec{}
+	commands := []CommandSpec{}
+	for _, a := range argsList {
+		switch v := a.(type) {
+		case PositionalArg:
+			positionals = append(positionals, describePositional(v))
+		case *Command:
+			commands = append(commands, describeCommand(v))
+		default:
+			flags = append(flags, describeFlag(a))
+		}
+	}
+	return flags, positionals, commands
+}
+
+// describeCommand produces the description of a command and of its subcommands
+func describeCommand(c *Command) CommandSpec {
+	c.SortArgsList()
+	spec := CommandSpec{
+		Name:               c.name,
+		Aliases:            append([]string{}, c.aliases...),
+		Help:               c.Help,
+		Deprecated:         c.deprecated,
+		SubcommandRequired: c.subRequired,
+	}
+	spec.Flags, spec.Positionals, spec.Commands = describeArgs(c.argsList)
+	return spec
+}
+
+// describePositional produces the description of a positional argument
+func describePositional(a PositionalArg) PositionalSpec {
+	kinds := map[Kind]string{KindString: "string", KindInt: "int", KindFloat: "float", KindBool: "bool"}
+	return PositionalSpec{
+		Name:     a.Name,
+		Help:     a.Help,
+		Kind:     kinds[a.Kind],
+		Required: a.Required,
+		Variadic: a.Variadic,
+	}
+}
+
+// describeFlag produces the description of a flag
+func describeFlag(a Argument) FlagSpec {
+	spec := FlagSpec{
+		NArgs:      a.Arity(),
+		Required:   isRequiredFlag(a),
+		Hidden:     isHidden(a),
+		Deprecated: deprecationMessage(a),
+		Group:      flagGroup(a),
+		Example:    flagExample(a),
+	}
+
+	switch f := a.(type) {
+	case StringFlag:
+		spec.Name, spec.Short, spec.Help, spec.Type = f.Name, f.Short, f.Help, "string"
+	case ChoiceFlag:
+		spec.Name, spec.Short, spec.Help, spec.Type = f.Name, f.Short, f.Help, "choice"
+		spec.Choices = append([]string{}, f.Choices...)
+	case IntFlag:
+		spec.Name, spec.Short, spec.Help, spec.Type = f.Name, f.Short, f.Help, "int"
+	case FloatFlag:
+		spec.Name, spec.Short, spec.Help, spec.Type = f.Name, f.Short, f.Help, "float"
+	case DurationFlag:
+		spec.Name, spec.Short, spec.Help, spec.Type = f.Name, f.Short, f.Help, "duration"
+	case ListFlag:
+		spec.Name, spec.Short, spec.Help, spec.Type = f.Name, f.Short, f.Help, "list"
+	case BoolFlag:
+		spec.Name, spec.Short, spec.Help, spec.Type = f.Name, f.Short, f.Help, "bool"
+	case CountFlag:
+		spec.Name, spec.Short, spec.Help, spec.Type = f.Name, f.Short, f.Help, "count"
+	case HelpFlag:
+		spec.Name, spec.Short, spec.Help, spec.Type = f.LongArg()[2:], f.ShortArg()[1:], f.Help, "help"
+	case VersionFlag:
+		spec.Name, spec.Short, spec.Help, spec.Type = "version", "V", f.Help, "version"
+	}
+	return spec
+}
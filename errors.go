@@ -0,0 +1,126 @@
+package argmap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrHelpRequested is returned by the parsing functions when the help flag is inserted and
+// the parser is set not to exit on help (see SetExitOnHelp)
+var ErrHelpRequested = errors.New("help requested")
+
+// ErrVersionRequested is returned by the parsing functions when the version flag is inserted
+// and the parser is set not to exit on help (see SetExitOnHelp)
+var ErrVersionRequested = errors.New("version requested")
+
+// UnrecognizedArgError is returned when a token is neither a flag nor a command and there
+// are no more positional arguments left to be assigned. Suggestion is the closest long flag
+// when suggestions are enabled (see SetSuggestions)
+type UnrecognizedArgError struct {
+	Arg        string
+	Suggestion string
+}
+
+func (e UnrecognizedArgError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("Error: unrecognized argument '%s', did you mean '%s'?", e.Arg, e.Suggestion)
+	}
+	return fmt.Sprintf("Error: unrecognized argument '%s'", e.Arg)
+}
+
+// UnknownCommandError is returned in strict command mode (see SetStrictCommands) when the first
+// token which is not a flag doesn't match any command. Available lists the command names
+type UnknownCommandError struct {
+	Name      string
+	Available []string
+}
+
+func (e UnknownCommandError) Error() string {
+	return fmt.Sprintf("Error: unknown command '%s' (available: %s)", e.Name, strings.Join(e.Available, ", "))
+}
+
+// CommandNotInvokedError is returned by GetSubcommandMap when a command of the path is not in
+// the map. Path holds the names from the top-level command to the missing one
+type CommandNotInvokedError struct {
+	Path []string
+}
+
+func (e CommandNotInvokedError) Error() string {
+	return fmt.Sprintf("Error: command '%s' was not invoked", strings.Join(e.Path, " "))
+}
+
+// MissingPositionalError is returned when a required positional argument is not inserted
+type MissingPositionalError struct {
+	Name string
+}
+
+func (e MissingPositionalError) Error() string {
+	return fmt.Sprintf("Error: missing required positional argument '%s'", e.Name)
+}
+
+// MissingFlagError is returned when a required flag is not inserted
+type MissingFlagError struct {
+	Flag string
+}
+
+func (e MissingFlagError) Error() string {
+	return fmt.Sprintf("Error: missing required flag '%s'", e.Flag)
+}
+
+// MissingArgumentError is returned when an argument declared as required after its insertion
+// (see ArgsParser.Require) is not in the parsed map
+type MissingArgumentError struct {
+	Key string
+}
+
+func (e MissingArgumentError) Error() string {
+	return fmt.Sprintf("Error: missing required argument '%s'", e.Key)
+}
+
+// IncorrectUsageError is returned when a flag doesn't get the number of values it needs.
+// Next is the token which stopped the values (empty if the arguments ended before), while Var
+// is the name of the first missing value for StringFlags with more named values (see Vars)
+type IncorrectUsageError struct {
+	Flag      string
+	Expected  int
+	Available int
+	Next      string
+	Var       string
+}
+
+func (e IncorrectUsageError) Error() string {
+	if e.Var != "" && e.Next != "" {
+		return fmt.Sprintf("Error: value for '%s %s' is missing before '%s'", e.Flag, e.Var, e.Next)
+	} else if e.Var != "" {
+		return fmt.Sprintf("Error: value for '%s %s' is missing", e.Flag, e.Var)
+	} else if e.Next != "" && e.Expected == 1 {
+		return fmt.Sprintf("Error: %s needs 1 value but none is available before '%s'", e.Flag, e.Next)
+	} else if e.Next != "" {
+		return fmt.Sprintf("Error: %s needs %d values but only %d available before '%s'", e.Flag, e.Expected, e.Available, e.Next)
+	}
+	return fmt.Sprintf("Error: incorrect arguments number for flag '%s'", e.Flag)
+}
+
+// errMissingSubcommand is wrapped in a CommandError when a command requiring a subcommand
+// (see SetSubcommandRequired) is invoked alone
+var errMissingSubcommand = errors.New("Error: missing required subcommand")
+
+// CommandError wraps an error occurred while parsing the arguments of a command.
+// Path holds the names of the commands from the top-level one to the failing one
+type CommandError struct {
+	Path []string
+	Err  error
+}
+
+func (e CommandError) Error() string {
+	if e.Err == errMissingSubcommand {
+		return fmt.Sprintf("Error: command '%s' requires a subcommand", strings.Join(e.Path, " "))
+	}
+	return fmt.Sprintf("%s for command '%s'", e.Err, strings.Join(e.Path, " "))
+}
+
+// Unwrap returns the underlying error, so that errors.As can find its type
+func (e CommandError) Unwrap() error {
+	return e.Err
+}
@@ -12,7 +12,7 @@ func main() {
 	parser.NewBoolFlag(argmap.BoolFlag{Name: "spanish"})
 
 	aMap, _ := parser.Parse()
-	name, _ := argmap.GetListValue(aMap, "hello", 0)
+	name := argmap.GetString(aMap, "hello")
 	if argmap.GetBool(aMap, "spanish") {
 		fmt.Printf("Hola %s\n", name)
 	} else {
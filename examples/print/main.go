@@ -11,6 +11,7 @@ func initParser() *argmap.ArgsParser {
 	parser := argmap.NewArgsParser("Printer", "Shows you something from command line")
 	parser.NewCommand(argmap.CommandParams{Name: "hello", Help: "greets the user"})
 	printer, _ := parser.NewCommand(argmap.CommandParams{Name: "print", Help: "prints a string or the content of a file"})
+	printer.SetSubcommandRequired(true)
 
 	str, _ := printer.NewSubcommand(argmap.CommandParams{Name: "string", Help: "prints a string"})
 	str.NewPositionalArg(argmap.PositionalArg{Name: "input", Help: "the input string", Required: true})
@@ -28,7 +29,7 @@ func main() {
 		parser.ReportError(err)
 	}
 
-	cmd, cmdMap, err := argmap.GetCommandMap(aMap)
+	cmd, _, err := argmap.GetCommandMap(aMap)
 	if err != nil {
 		parser.ReportError(fmt.Errorf("Please type a command to be executed"))
 	}
@@ -37,17 +38,11 @@ func main() {
 	case "hello":
 		fmt.Println("Nice to meet you!")
 	case "print":
-		sub, subMap, err := argmap.GetCommandMap(cmdMap)
-		if err != nil {
-			parser.ReportError(fmt.Errorf("Missing subcommand for command 'print'"))
-		}
-
-		switch sub {
-		case "string":
-			input, _ := argmap.GetPositional(subMap, "input")
+		if strMap, err := argmap.GetSubcommandMap(aMap, "print", "string"); err == nil {
+			input, _ := argmap.GetPositional(strMap, "input")
 			fmt.Println(input)
-		case "file":
-			path, _ := argmap.GetPositional(subMap, "path")
+		} else if fileMap, err := argmap.GetSubcommandMap(aMap, "print", "file"); err == nil {
+			path, _ := argmap.GetPositional(fileMap, "path")
 			data, err := ioutil.ReadFile(path)
 			if err != nil {
 				fmt.Println(err)
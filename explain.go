@@ -0,0 +1,81 @@
+package argmap
+
+import "context"
+
+// TokenKind tells how a token has been interpreted by the parser (see Explain)
+type TokenKind int
+
+// Possible interpretations of a token: a flag, a value of the flag before it, a positional
+// argument, a command, the "--" terminator, a token captured as rest (see SetCaptureRest and
+// SetStopAtCommand), an extra token (see SetExtraPositionalPolicy) or an unrecognized one
+const (
+	TokenFlag TokenKind = iota
+	TokenValue
+	TokenPositional
+	TokenCommand
+	TokenTerminator
+	TokenRest
+	TokenExtra
+	TokenUnrecognized
+)
+
+func (k TokenKind) String() string {
+	names := []string{"flag", "value", "positional", "command", "terminator", "rest", "extra", "unrecognized"}
+	if k < 0 || int(k) >= len(names) {
+		return "unknown"
+	}
+	return names[k]
+}
+
+// TokenInterpretation describes what the parser decided for a token
+//  Token      the token as seen by the parser (bunched short flags are split, e.g. "-vq" into "-v" and "-q")
+//  Kind       how the token has been interpreted
+//  Key        identifier of the flag, positional or command (for values, the one of their flag)
+//  Command    path of the command whose arguments include the token (empty for the program)
+type TokenInterpretation struct {
+	Token   string
+	Kind    TokenKind
+	Key     string
+	Command []string
+}
+
+// Explain parses the arguments (without the program name) as ParseFrom does, but returns how
+// each token has been interpreted instead of the map. It's meant to debug why an argument
+// ended up where it did: the help and the warnings are not printed, the program doesn't exit
+// and, in case of error, the tokens interpreted before it are returned along with it
+func (p *ArgsParser) Explain(args []string) ([]TokenInterpretation, error) {
+	cfg := p.callConfig(context.Background())
+	cfg.explain = &explainer{tokens: []TokenInterpretation{}}
+
+	_, err := p.parse(cfg, args, nil)
+	if err == ErrHelpRequested || err == ErrVersionRequested {
+		err = nil
+	}
+	return cfg.explain.tokens, err
+}
+
+// explainer collects the interpretations of the tokens. It is only set up by Explain, so that
+// the other parsings just check that it's nil
+type explainer struct {
+	tokens []TokenInterpretation
+}
+
+// record adds the interpretation of the tokens when explaining the parsing (see Explain).
+// It's kept small enough to be inlined in parseArgs
+func (cfg *parseConfig) record(kind TokenKind, key string, tokens ...string) {
+	if cfg.explain != nil {
+		cfg.explain.add(kind, key, cfg.cmdPath, tokens)
+	}
+}
+
+// add appends the interpretation of the tokens, found in the arguments of the command path
+func (e *explainer) add(kind TokenKind, key string, path []string, tokens []string) {
+	for _, t := range tokens {
+		e.tokens = append(e.tokens, TokenInterpretation{
+			Token:   t,
+			Kind:    kind,
+			Key:     key,
+			Command: append([]string{}, path...),
+		})
+	}
+}
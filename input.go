@@ -0,0 +1,170 @@
+package argmap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// ParseReader reads a single line from the reader, splits it in arguments with a shell-like
+// syntax (see SplitArgs) and parses them with ParseFrom. Useful for interactive prompts
+// where each line is a new invocation. Returns io.EOF if there are no more lines to be read.
+// Nothing past the end of the line is consumed, so the reader can be passed again to read the
+// next line (a *bufio.Reader is used as it is)
+func (p *ArgsParser) ParseReader(r io.Reader) (map[string]interface{}, error) {
+	line, err := readLine(r)
+	if err != nil && (err != io.EOF || line == "") {
+		return nil, err
+	}
+
+	args, err := SplitArgs(line)
+	if err != nil {
+		return nil, err
+	}
+	return p.ParseFrom(args)
+}
+
+// readLine reads up to the first newline (included) without buffering the following bytes
+func readLine(r io.Reader) (string, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = byteReader{r}
+	}
+
+	var line strings.Builder
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return line.String(), err
+		}
+		line.WriteByte(c)
+		if c == '\n' {
+			return line.String(), nil
+		}
+	}
+}
+
+// byteReader reads a byte at a time from a reader which is not an io.ByteReader
+type byteReader struct {
+	r io.Reader
+}
+
+func (b byteReader) ReadByte() (byte, error) {
+	var buf [1]byte
+	for {
+		n, err := b.r.Read(buf[:])
+		if n == 1 {
+			return buf[0], nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// SplitArgs splits a string in arguments like a shell would do: arguments are separated
+// by whitespaces, unless they are inside single or double quotes. A backslash escapes the
+// following character, except inside single quotes
+func SplitArgs(s string) ([]string, error) {
+	args := []string{}
+	var current strings.Builder
+	inArg := false
+	var quote rune
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(c)
+			}
+		case c == '\\':
+			if i+1 == len(runes) {
+				return nil, fmt.Errorf("Error: unterminated escape at the end of '%s'", s)
+			}
+			i++
+			if quote == '"' && !strings.ContainsRune("\"\\$`", runes[i]) {
+				current.WriteRune(c)
+			}
+			current.WriteRune(runes[i])
+			inArg = true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				current.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(c)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("Error: unterminated quote in '%s'", s)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// maxResponseDepth is the maximum nesting of response files, guarding against cycles
+const maxResponseDepth = 10
+
+// SetResponseFilePrefix enables response files: a token starting with the prefix (usually '@')
+// is replaced by the arguments read from the named file (e.g. "@args.txt"), split like a shell
+// would do (see SplitArgs). References inside the files are expanded too, while the tokens
+// after "--" are left untouched. A zero prefix disables the expansion (default)
+func (p *ArgsParser) SetResponseFilePrefix(prefix byte) {
+	p.respPrefix = prefix
+}
+
+// expandResponseFiles replaces the response file references with their arguments, stopping
+// when the context is done
+func expandResponseFiles(ctx context.Context, args []string, prefix byte, depth int) ([]string, error) {
+	expanded := []string{}
+	for i, arg := range args {
+		if arg == "--" {
+			return append(expanded, args[i:]...), nil
+		}
+		if len(arg) < 2 || arg[0] != prefix {
+			expanded = append(expanded, arg)
+			continue
+		}
+
+		path := arg[1:]
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if depth >= maxResponseDepth {
+			return nil, fmt.Errorf("Error: response files nested too deeply at '%s'", path)
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Error: cannot read response file '%s': %s", path, err)
+		}
+		fileArgs, err := SplitArgs(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("Error: invalid response file '%s': %s", path, err)
+		}
+		if fileArgs, err = expandResponseFiles(ctx, fileArgs, prefix, depth+1); err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, fileArgs...)
+	}
+	return expanded, nil
+}
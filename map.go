@@ -1,6 +1,13 @@
 package argmap
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // IsPresent just tells if an argument is present in the map
 func IsPresent(aMap map[string]interface{}, key string) bool {
@@ -8,9 +15,27 @@ func IsPresent(aMap map[string]interface{}, key string) bool {
 	return ok
 }
 
+// IsPresentPath tells if the last key is present in the map of the command reached by walking
+// the previous ones (e.g. "print", "file", "path"). Returns false if any intermediate key
+// is missing or is not a command map
+func IsPresentPath(aMap map[string]interface{}, keys ...string) bool {
+	if len(keys) == 0 {
+		return false
+	}
+	for _, key := range keys[:len(keys)-1] {
+		cmdMap, ok := GetSubMap(aMap, key)
+		if !ok {
+			return false
+		}
+		aMap = cmdMap
+	}
+	return IsPresent(aMap, keys[len(keys)-1])
+}
+
 // GetList searches the map and possibly returns the list of argument values of a StringFlag
 // or a ListFlag. An error is returned if the key is not in the map or the identifier does
-// not indicate a slice of strings.
+// not indicate a slice of strings. Since both flags store a slice of strings, the two
+// types are not distinguished.
 func GetList(aMap map[string]interface{}, key string) ([]string, error) {
 	if argList, ok := aMap[key]; ok {
 		if valuesList, ok := argList.([]string); ok {
@@ -21,8 +46,9 @@ func GetList(aMap map[string]interface{}, key string) ([]string, error) {
 	return nil, fmt.Errorf("Error: key not found in map")
 }
 
-// GetListValue searches the map and the list of output values of a flag in order to return
-// the one at the specified index. An error is returned if the index exceeds the slice bounds.
+// GetListValue searches the map and the list of output values of a StringFlag or a ListFlag
+// in order to return the one at the specified index. An error is returned if the index
+// exceeds the slice bounds.
 func GetListValue(aMap map[string]interface{}, key string, index int) (string, error) {
 	valuesList, err := GetList(aMap, key)
 	if err != nil {
@@ -33,6 +59,150 @@ func GetListValue(aMap map[string]interface{}, key string, index int) (string, e
 	return valuesList[index], nil
 }
 
+// GetString returns the first value of a StringFlag, or an empty string if the flag is not
+// present or has no values. Useful for the common single-value flags: use GetListValue when
+// the errors need to be handled
+func GetString(aMap map[string]interface{}, key string) string {
+	if valuesList, ok := aMap[key].([]string); ok && len(valuesList) > 0 {
+		return valuesList[0]
+	}
+	return ""
+}
+
+// GetIntArray searches the map and possibly returns the list of values of an IntFlag.
+// An error is returned if the key is not in the map or the identifier does not
+// indicate a slice of integers.
+func GetIntArray(aMap map[string]interface{}, key string) ([]int, error) {
+	if argList, ok := aMap[key]; ok {
+		if valuesList, ok := argList.([]int); ok {
+			return valuesList, nil
+		}
+		return nil, fmt.Errorf("Error: argument is not an integer list")
+	}
+	return nil, fmt.Errorf("Error: key not found in map")
+}
+
+// GetInt searches the map and the list of values of an IntFlag in order to return the one
+// at the specified index. An error is returned if the index exceeds the slice bounds.
+func GetInt(aMap map[string]interface{}, key string, index int) (int, error) {
+	valuesList, err := GetIntArray(aMap, key)
+	if err != nil {
+		return 0, err
+	} else if index >= len(valuesList) || index < 0 {
+		return 0, fmt.Errorf("Error: index out of bound")
+	}
+	return valuesList[index], nil
+}
+
+// GetFloatArray searches the map and possibly returns the list of values of an FloatFlag.
+// An error is returned if the key is not in the map or the identifier does not
+// indicate a slice of floats.
+func GetFloatArray(aMap map[string]interface{}, key string) ([]float64, error) {
+	if argList, ok := aMap[key]; ok {
+		if valuesList, ok := argList.([]float64); ok {
+			return valuesList, nil
+		}
+		return nil, fmt.Errorf("Error: argument is not a float list")
+	}
+	return nil, fmt.Errorf("Error: key not found in map")
+}
+
+// GetFloat searches the map and the list of values of an FloatFlag in order to return the one
+// at the specified index. An error is returned if the index exceeds the slice bounds.
+func GetFloat(aMap map[string]interface{}, key string, index int) (float64, error) {
+	valuesList, err := GetFloatArray(aMap, key)
+	if err != nil {
+		return 0, err
+	} else if index >= len(valuesList) || index < 0 {
+		return 0, fmt.Errorf("Error: index out of bound")
+	}
+	return valuesList[index], nil
+}
+
+// GetDurationArray searches the map and possibly returns the list of values of a DurationFlag.
+// An error is returned if the key is not in the map or the identifier does not
+// indicate a slice of durations.
+func GetDurationArray(aMap map[string]interface{}, key string) ([]time.Duration, error) {
+	if argList, ok := aMap[key]; ok {
+		if valuesList, ok := argList.([]time.Duration); ok {
+			return valuesList, nil
+		}
+		return nil, fmt.Errorf("Error: argument is not a duration list")
+	}
+	return nil, fmt.Errorf("Error: key not found in map")
+}
+
+// GetDuration searches the map and the list of values of a DurationFlag in order to return the one
+// at the specified index. An error is returned if the index exceeds the slice bounds.
+func GetDuration(aMap map[string]interface{}, key string, index int) (time.Duration, error) {
+	valuesList, err := GetDurationArray(aMap, key)
+	if err != nil {
+		return 0, err
+	} else if index >= len(valuesList) || index < 0 {
+		return 0, fmt.Errorf("Error: index out of bound")
+	}
+	return valuesList[index], nil
+}
+
+// GetIntSlice converts all the values of a StringFlag or a ListFlag to integers. An error
+// is returned for the first value which is not an integer
+func GetIntSlice(aMap map[string]interface{}, key string) ([]int, error) {
+	valuesList, err := GetList(aMap, key)
+	if err != nil {
+		return nil, err
+	}
+
+	ints := make([]int, len(valuesList))
+	for i, v := range valuesList {
+		if ints[i], err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("Error: value '%s' at index %d is not an integer", v, i)
+		}
+	}
+	return ints, nil
+}
+
+// GetFloatSlice converts all the values of a StringFlag or a ListFlag to floats. An error
+// is returned for the first value which is not a number
+func GetFloatSlice(aMap map[string]interface{}, key string) ([]float64, error) {
+	valuesList, err := GetList(aMap, key)
+	if err != nil {
+		return nil, err
+	}
+
+	floats := make([]float64, len(valuesList))
+	for i, v := range valuesList {
+		if floats[i], err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf("Error: value '%s' at index %d is not a number", v, i)
+		}
+	}
+	return floats, nil
+}
+
+// GetOrderedPairs splits the values of a StringFlag or a ListFlag in key=value pairs, keeping
+// their order and possible duplicate keys. An error is returned if a value has no "=" separator
+func GetOrderedPairs(aMap map[string]interface{}, key string) ([][2]string, error) {
+	return GetOrderedPairsSep(aMap, key, "=")
+}
+
+// GetOrderedPairsSep works like GetOrderedPairs, but splits each value at the first
+// occurrence of the given separator (e.g. ":" for "--header Accept:text/html")
+func GetOrderedPairsSep(aMap map[string]interface{}, key, sep string) ([][2]string, error) {
+	valuesList, err := GetList(aMap, key)
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := make([][2]string, len(valuesList))
+	for i, v := range valuesList {
+		kv := strings.SplitN(v, sep, 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Error: value '%s' is not a key%svalue pair", v, sep)
+		}
+		pairs[i] = [2]string{kv[0], kv[1]}
+	}
+	return pairs, nil
+}
+
 // GetBool searches the map for the boolean value of a BoolFlag. If not present, returns false.
 func GetBool(aMap map[string]interface{}, key string) bool {
 	if boolValue, ok := aMap[key]; ok {
@@ -43,6 +213,14 @@ func GetBool(aMap map[string]interface{}, key string) bool {
 	return false
 }
 
+// GetCount returns how many times a CountFlag has been inserted. If not present, returns 0.
+func GetCount(aMap map[string]interface{}, key string) int {
+	if count, ok := aMap[key].(int); ok {
+		return count
+	}
+	return 0
+}
+
 // GetPositional returns the string value (if present) of the indicated positional argument.
 // Returns an error if it isn't a positional or the key isn't to be found
 func GetPositional(aMap map[string]interface{}, key string) (string, error) {
@@ -55,13 +233,246 @@ func GetPositional(aMap map[string]interface{}, key string) (string, error) {
 	return "", fmt.Errorf("Error: key not found in map")
 }
 
+// GetIntPositional returns the value (if present) of the indicated positional argument
+// converted to an integer. Returns an error if the value isn't an integer or the key isn't
+// to be found. Positionals of KindInt are returned as they are
+func GetIntPositional(aMap map[string]interface{}, key string) (int, error) {
+	if posArg, ok := aMap[key]; ok {
+		switch v := posArg.(type) {
+		case int:
+			return v, nil
+		case string:
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				return 0, fmt.Errorf("Error: value '%s' of positional argument '%s' is not an integer", v, key)
+			}
+			return i, nil
+		}
+		return 0, fmt.Errorf("Error: argument is not an integer positional")
+	}
+	return 0, fmt.Errorf("Error: key not found in map")
+}
+
+// GetFloatPositional returns the value (if present) of the indicated positional argument
+// converted to a float. Returns an error if the value isn't a number or the key isn't
+// to be found. Positionals of KindFloat and KindInt are returned as they are
+func GetFloatPositional(aMap map[string]interface{}, key string) (float64, error) {
+	if posArg, ok := aMap[key]; ok {
+		switch v := posArg.(type) {
+		case float64:
+			return v, nil
+		case int:
+			return float64(v), nil
+		case string:
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return 0, fmt.Errorf("Error: value '%s' of positional argument '%s' is not a number", v, key)
+			}
+			return f, nil
+		}
+		return 0, fmt.Errorf("Error: argument is not a numeric positional")
+	}
+	return 0, fmt.Errorf("Error: key not found in map")
+}
+
+// GetBoolPositional returns the value (if present) of the indicated positional argument
+// converted to a boolean, accepting the same values of "--flag=value" (true/false, 1/0, etc.).
+// Returns an error if the value isn't a boolean or the key isn't to be found
+func GetBoolPositional(aMap map[string]interface{}, key string) (bool, error) {
+	if posArg, ok := aMap[key]; ok {
+		switch v := posArg.(type) {
+		case bool:
+			return v, nil
+		case string:
+			b, err := parseBoolString(v)
+			if err != nil {
+				return false, fmt.Errorf("Error: value '%s' of positional argument '%s' is not a boolean", v, key)
+			}
+			return b, nil
+		}
+		return false, fmt.Errorf("Error: argument is not a boolean positional")
+	}
+	return false, fmt.Errorf("Error: key not found in map")
+}
+
+// GetVariadic returns the values (if present) of the indicated variadic positional argument.
+// Returns an error if it isn't a variadic positional or the key isn't to be found
+func GetVariadic(aMap map[string]interface{}, key string) ([]string, error) {
+	if posArg, ok := aMap[key]; ok {
+		if values, ok := posArg.([]string); ok {
+			return values, nil
+		}
+		return nil, fmt.Errorf("Error: argument is not a variadic positional")
+	}
+	return nil, fmt.Errorf("Error: key not found in map")
+}
+
+// GetTypedPositional returns the value (if present) of the indicated positional argument,
+// converted according to its Kind (string, int, float64 or bool).
+// Returns an error if the key isn't to be found
+func GetTypedPositional(aMap map[string]interface{}, key string) (interface{}, error) {
+	if posArg, ok := aMap[key]; ok {
+		switch posArg.(type) {
+		case string, int, float64, bool:
+			return posArg, nil
+		}
+		return nil, fmt.Errorf("Error: argument is not a positional")
+	}
+	return nil, fmt.Errorf("Error: key not found in map")
+}
+
 // GetCommandMap returns the name of the inserted command in the map and the corresponding argument
-// map for that command. Returns an error if no command has been invoked by the user
+// map for that command. Returns an error if no command has been invoked by the user.
+// A command stored as nil is still a valid command: a non-nil empty map is returned for it.
+// The first map value in the order of the keys is taken: if the map may hold other map values
+// (e.g. passed to ParseWithDefaults), use the GetCommandMap method of the parser instead
 func GetCommandMap(aMap map[string]interface{}) (string, map[string]interface{}, error) {
-	for key, value := range aMap {
+	keys := make([]string, 0, len(aMap))
+	for key := range aMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := aMap[key]
+		if value == nil {
+			return key, map[string]interface{}{}, nil
+		}
 		if cmdMap, ok := value.(map[string]interface{}); ok {
+			if cmdMap == nil {
+				cmdMap = map[string]interface{}{}
+			}
 			return key, cmdMap, nil
 		}
 	}
 	return "", nil, fmt.Errorf("Error: no command found in map")
 }
+
+// GetSubcommandMap walks the given command path (e.g. "print", "file") and returns the argument
+// map of the last command. A CommandNotInvokedError naming the missing command is returned if
+// any level wasn't invoked
+func GetSubcommandMap(aMap map[string]interface{}, path ...string) (map[string]interface{}, error) {
+	for i, name := range path {
+		cmdMap, ok := GetSubMap(aMap, name)
+		if !ok {
+			return nil, CommandNotInvokedError{Path: append([]string{}, path[:i+1]...)}
+		}
+		aMap = cmdMap
+	}
+	return aMap, nil
+}
+
+// GetCommandPath returns the names of the invoked commands, from the top-level one to the
+// deepest subcommand (e.g. ["print", "file"]). The slice is empty if no command is invoked
+func GetCommandPath(aMap map[string]interface{}) []string {
+	path := []string{}
+	for {
+		cmd, cmdMap, err := GetCommandMap(aMap)
+		if err != nil {
+			return path
+		}
+		path = append(path, cmd)
+		aMap = cmdMap
+	}
+}
+
+// GetAll flattens the parsed map, descending into the command maps and joining the keys with
+// dots (e.g. "print.file.path"). The values keep their types, while the command trace of the
+// help is dropped. The invoked commands can be retrieved with GetCommandPath
+func GetAll(aMap map[string]interface{}) map[string]interface{} {
+	flat := make(map[string]interface{})
+	flattenMap(aMap, "", flat)
+	return flat
+}
+
+// flattenMap copies the values of the map in flat, prefixing the keys
+func flattenMap(aMap map[string]interface{}, prefix string, flat map[string]interface{}) {
+	for key, value := range aMap {
+		if key == "trace" {
+			continue
+		}
+		switch v := value.(type) {
+		case nil:
+		case map[string]interface{}:
+			flattenMap(v, prefix+key+".", flat)
+		default:
+			flat[prefix+key] = value
+		}
+	}
+}
+
+// GetSubMap returns the argument map of the indicated command and true if the command is
+// present in the map. The returned map is never nil, even if the command was stored as nil
+func GetSubMap(aMap map[string]interface{}, cmdName string) (map[string]interface{}, bool) {
+	value, ok := aMap[cmdName]
+	if !ok {
+		return nil, false
+	}
+	if value == nil {
+		return map[string]interface{}{}, true
+	}
+	if cmdMap, ok := value.(map[string]interface{}); ok {
+		if cmdMap == nil {
+			cmdMap = map[string]interface{}{}
+		}
+		return cmdMap, true
+	}
+	return nil, false
+}
+
+// PresentKeys returns the top-level keys of the parsed map in alphabetical order, excluding
+// the command trace of the help
+func PresentKeys(aMap map[string]interface{}) []string {
+	keys := []string{}
+	for key := range aMap {
+		if key != "trace" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Describe renders each top-level value of the parsed map as a string: lists are comma-joined,
+// booleans are "true" or "false" and commands are noted as "command: name".
+// The command trace of the help is excluded
+func Describe(aMap map[string]interface{}) map[string]string {
+	desc := make(map[string]string)
+	for _, key := range PresentKeys(aMap) {
+		switch aMap[key].(type) {
+		case nil, map[string]interface{}:
+			desc[key] = "command: " + key
+		default:
+			desc[key] = valueString(aMap[key])
+		}
+	}
+	return desc
+}
+
+// MarshalJSON encodes the parsed map as JSON, with the keys sorted. Commands are rendered as
+// nested objects, while the command trace of the help (if any) is rendered as the list of
+// the command names
+func MarshalJSON(aMap map[string]interface{}) ([]byte, error) {
+	return json.Marshal(jsonValue(aMap))
+}
+
+// jsonValue replaces the values of the map which can't be directly encoded
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return map[string]interface{}{}
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			m[key] = jsonValue(elem)
+		}
+		return m
+	case []*Command:
+		names := make([]string, len(v))
+		for i, c := range v {
+			names[i] = c.GetID()
+		}
+		return names
+	}
+	return value
+}
@@ -9,98 +9,341 @@
 package argmap
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 )
 
 // HelpMessageGenerator type used to allow customizable help messages
 type HelpMessageGenerator func(*ArgsParser, []*Command) string
 
+// ArgsPreprocessor type used to rewrite the arguments before they are parsed
+type ArgsPreprocessor func([]string) ([]string, error)
+
+// ArgsPreprocessorContext type used to rewrite the arguments before they are parsed, getting
+// the context of the parsing (see ParseContext)
+type ArgsPreprocessorContext func(context.Context, []string) ([]string, error)
+
+// TraceFormatter type used to allow customizable "Reference:" lines in the command help.
+// The trace starts from the invoked command and ends with the top-level one
+type TraceFormatter func([]*Command) string
+
+// ExamplesPosition tells where the examples are shown in the default program help
+type ExamplesPosition int
+
+// Possible positions of the examples in the help: at the bottom (default) or right after the description
+const (
+	ExamplesBottom ExamplesPosition = iota
+	ExamplesTop
+)
+
+// ExtraPositionalPolicy tells what to do with the tokens exceeding the positionals
+type ExtraPositionalPolicy int
+
+// Possible policies for the extra tokens: return an UnrecognizedArgError (default), skip them
+// or collect them in the map under the ExtraKey
+const (
+	ExtraError ExtraPositionalPolicy = iota
+	ExtraIgnore
+	ExtraCollect
+)
+
+// ExtraKey is the map key of the extra tokens collected with the ExtraCollect policy
+const ExtraKey = "_extra"
+
+// RestKey is the map key of the raw arguments following a command when the parser
+// is set to stop at commands (see SetStopAtCommand)
+const RestKey = "__rest__"
+
+// parseConfig gathers the parser options affecting how the arguments are parsed
+type parseConfig struct {
+	stopAtCommand   bool
+	captureRest     string
+	extraPolicy     ExtraPositionalPolicy
+	abbreviations   bool
+	suggestCommands bool
+	suggestFlags    bool
+	caseInsensitive bool
+	strictValues    bool
+	singleDashLong  bool
+	strictCommands  bool
+	envPrefix       string
+	defaults        map[string][]string
+	strictDefaults  bool
+	cmdPath         []string
+	warnings        []string
+	ctx             context.Context
+	explain         *explainer
+}
+
+// context returns the context of the parsing (see ParseContext)
+func (cfg *parseConfig) context() context.Context {
+	if cfg.ctx == nil {
+		return context.Background()
+	}
+	return cfg.ctx
+}
+
+// cancelled returns the error of the parsing context if it's been cancelled (nil otherwise)
+func (cfg *parseConfig) cancelled() error {
+	return cfg.context().Err()
+}
+
 // ArgsParser stores the list of possible arguments
 type ArgsParser struct {
 	Name        string
 	Description string
 	argsList    []Argument
+	index       *argsIndex
 	helpGen     HelpMessageGenerator
+	traceFmt    TraceFormatter
+	handler     CommandHandler
+	preprocess  ArgsPreprocessorContext
+	requires    []requirement
+	required    []string
+	quietErrors bool
+	noExitOnErr bool
+	noExitOnHlp bool
+	noHelpFlag  bool
+	out         io.Writer
+	errOut      io.Writer
+	multiCall   bool
+	config      parseConfig
+	version     string
+	helpHeader  string
+	helpFooter  string
+	examples    []string
+	examplesPos ExamplesPosition
+	helpWidth   int
+	printWarns  bool
+	respPrefix  byte
 }
 
 // NewArgsParser function to return an initialized struct
 func NewArgsParser(name, descr string) ArgsParser {
-	var helpArg = []Argument{HelpFlag{"shows help message and exits"}}
+	var helpArg = []Argument{HelpFlag{Help: "shows help message and exits"}}
 
 	return ArgsParser{
 		Name:        name,
 		Description: descr,
 		argsList:    helpArg,
+		index:       &argsIndex{},
 		helpGen:     DefaultHelp,
+		traceFmt:    DefaultTraceFormat,
 	}
 }
 
 // DefaultHelp produces the standard complete help message for the program
 func DefaultHelp(p *ArgsParser, cmdTrace []*Command) string {
 	help := fmt.Sprintf("%s\n%s\n", p.Name, p.Description)
+	if p.helpHeader != "" {
+		help = p.expandTemplate(p.helpHeader) + "\n"
+	}
 
 	if cmdTrace == nil || len(cmdTrace) == 0 {
 		// PROGRAM HELP
 		p.SortArgsList()
-		length := len(p.argsList)
-		argsHelp := make([][]string, length)
+		if p.examplesPos == ExamplesTop {
+			help += p.examplesHelp()
+		}
 
-		maxLeftLen := 0
-		commandsIndex := length
-		for i := 0; i < length; i++ {
-			argsHelp[i] = p.argsList[i].GetHelpStrings()
-			if len(argsHelp[i][0]) > maxLeftLen {
-				maxLeftLen = len(argsHelp[i][0])
-			}
+		help += "\n" + argumentsHelp(visibleArgs(p.argsList), "  ", "Commands", p.helpWidth)
 
-			if commandsIndex == length && p.argsList[i].getOrder() == orderCommand {
-				commandsIndex = i
-			}
+		if p.examplesPos == ExamplesBottom {
+			help += p.examplesHelp()
 		}
+	} else {
+		// COMMAND HELP
+		traceFmt := p.traceFmt
+		if traceFmt == nil {
+			traceFmt = DefaultTraceFormat
+		}
+
+		help += fmt.Sprintf("\nReference: %s\n", traceFmt(cmdTrace))
+		help += cmdTrace[0].GenerateHelp()
+	}
+
+	if p.helpFooter != "" {
+		help += "\n" + p.expandTemplate(p.helpFooter) + "\n"
+	}
+	return help
+}
 
-		if maxLeftLen > 40 {
-			maxLeftLen = 40
+// argumentsHelp produces the sections of a help message listing the sorted arguments: the
+// ungrouped ones under "Arguments:", the flags of each Group (in alphabetical order) under
+// their own heading and the commands under cmdHeading. All the sections share the same
+// alignment of the descriptions
+func argumentsHelp(argsList []Argument, indent, cmdHeading string, width int) string {
+	maxLeftLen := 0
+	ungrouped, commands := []Argument{}, []Argument{}
+	groups := make(map[string][]Argument)
+	for _, a := range argsList {
+		if left := a.GetHelpStrings()[0]; len(left) > maxLeftLen {
+			maxLeftLen = len(left)
 		}
 
-		help += "\nArguments:\n"
-		for i := 0; i < length; i++ {
-			if i == commandsIndex {
-				help += "\nCommands:\n"
-			}
+		if a.getOrder() == orderCommand {
+			commands = append(commands, a)
+		} else if group := flagGroup(a); group != "" {
+			groups[group] = append(groups[group], a)
+		} else {
+			ungrouped = append(ungrouped, a)
+		}
+	}
 
-			argStr := argsHelp[i][0]
-			for len(argStr) <= maxLeftLen {
-				argStr += " "
-			}
-			help += fmt.Sprintf("  %s %s\n", argStr, argsHelp[i][1])
+	if maxLeftLen > 40 {
+		maxLeftLen = 40
+	}
 
-			if i == length-1 && commandsIndex < length {
-				help += "Type -h or --help after a command for more details\n"
+	section := func(heading string, list []Argument) string {
+		lines := heading + ":\n"
+		for _, a := range list {
+			argHelp := a.GetHelpStrings()
+			lines += helpLine(indent, argHelp[0], maxLeftLen, argHelp[1], width)
+			if example := flagExample(a); example != "" {
+				reprs := a.Represent()
+				lines += strings.Repeat(" ", len(indent)+maxLeftLen+2)
+				lines += fmt.Sprintf("e.g. %s %s\n", reprs[len(reprs)-1], example)
 			}
 		}
-	} else {
-		// COMMAND HELP
-		traceString := ""
-		for i := len(cmdTrace) - 1; i >= 0; i-- {
-			traceString += fmt.Sprintf(" %s", cmdTrace[i].GetID())
-		}
+		return lines
+	}
 
-		help += fmt.Sprintf("\nReference: %s\n", traceString)
-		help += cmdTrace[0].GenerateHelp()
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
+	help := section("Arguments", ungrouped)
+	for _, name := range names {
+		help += "\n" + section(name, groups[name])
+	}
+	if len(commands) > 0 {
+		help += "\n" + section(cmdHeading, commands)
+		help += commandsHelpHint(commands)
+	}
 	return help
 }
 
-func parseArgs(args []string, argsList []Argument) (map[string]interface{}, error) {
+// commandsHelpHint returns the line telling how to get the help of the commands, naming
+// their help flag if they all share the same representations
+func commandsHelpHint(commands []Argument) string {
+	hint := ""
+	for _, c := range commands {
+		reprs := []string{}
+		if f, ok := findArg(c.(*Command).argsList, "help").(HelpFlag); ok {
+			reprs = f.Represent()
+		}
+		r := strings.Join(reprs, " or ")
+		if hint != "" && r != hint {
+			return "Type the help flag after a command for more details\n"
+		}
+		hint = r
+	}
+	if hint == "" {
+		return ""
+	}
+	return fmt.Sprintf("Type %s after a command for more details\n", hint)
+}
+
+// helpLine formats an argument of the help message: the left-hand side is padded to leftLen
+// and the description is word-wrapped within the width, indenting the continuation lines
+// (see SetHelpWidth). The lines of a multi-line description are kept as they are if they
+// fit, otherwise they are wrapped keeping their indentation. A word longer than the
+// available space gets a line of its own
+func helpLine(indent, left string, leftLen int, right string, width int) string {
+	for len(left) <= leftLen {
+		left += " "
+	}
+	line := indent + left + " "
+	if width < 0 {
+		return line + right + "\n"
+	}
+
+	width = effectiveHelpWidth(width)
+	column := len(indent) + leftLen + 2
+	available := width - column
+	if available < minHelpColumn {
+		available = minHelpColumn
+	}
+
+	// The lines following a newline start from the left margin as written in the description
+	lineLen := len(line)
+	margin := strings.Repeat(" ", column)
+	for n, text := range strings.Split(right, "\n") {
+		if n > 0 {
+			line += "\n"
+			lineLen = 0
+			margin = ""
+		}
+		if lineLen+len(text) <= column+available {
+			line += text
+			lineLen += len(text)
+			continue
+		}
+
+		lead := text[:len(text)-len(strings.TrimLeft(text, " \t"))]
+		line += lead
+		lineLen += len(lead)
+		for i, word := range strings.Fields(text) {
+			if i > 0 && lineLen+1+len(word) > column+available {
+				line += "\n" + margin + lead
+				lineLen = len(margin) + len(lead)
+			} else if i > 0 {
+				line += " "
+				lineLen++
+			}
+			line += word
+			lineLen += len(word)
+		}
+	}
+	return line + "\n"
+}
+
+// minHelpColumn is the minimum width of the descriptions column, kept even if the help
+// width is too narrow
+const minHelpColumn = 20
+
+// effectiveHelpWidth returns the width of the help message: the one set with SetHelpWidth or,
+// by default, the terminal width taken from $COLUMNS (80 if not available)
+func effectiveHelpWidth(width int) int {
+	if width > 0 {
+		return width
+	}
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	return 80
+}
+
+// DefaultTraceFormat produces the standard command path shown in the command help (e.g. " print file")
+func DefaultTraceFormat(cmdTrace []*Command) string {
+	traceString := ""
+	for i := len(cmdTrace) - 1; i >= 0; i-- {
+		traceString += fmt.Sprintf(" %s", cmdTrace[i].GetID())
+	}
+	return traceString
+}
+
+func parseArgs(args []string, argsList []Argument, cfg *parseConfig, presets map[string]interface{}) (map[string]interface{}, error) {
 	var argsMap = make(map[string]interface{})
+	for key, value := range presets {
+		argsMap[key] = value
+	}
 
 	var posIndex = 0
 	var posArgs = []int{}
 	var reqPos = []string{}
+	var reqFlags = []Argument{}
 
 	var reprMap = make(map[string]*Argument)
 	for i, a := range argsList {
@@ -112,35 +355,222 @@ func parseArgs(args []string, argsList []Argument) (map[string]interface{}, erro
 			continue
 		}
 
+		if isRequiredFlag(a) {
+			reqFlags = append(reqFlags, a)
+		}
+
 		for _, r := range a.Represent() {
+			if cfg.caseInsensitive && a.getOrder() == orderCommand {
+				r = strings.ToLower(r)
+			}
 			reprMap[r] = &argsList[i]
 		}
 	}
 
+	// Long flags also respond to a single dash (e.g. "-output"), unless a short flag has the same
+	// representation
+	if cfg.singleDashLong {
+		for i, a := range argsList {
+			for _, r := range a.Represent() {
+				if _, found := reprMap[r[1:]]; strings.HasPrefix(r, "--") && !found {
+					reprMap[r[1:]] = &argsList[i]
+				}
+			}
+		}
+	}
+
 	n := len(args)
+	terminated := false
+	attached := -1
 	for i := 0; i < n; i++ {
-		if arg, ok := reprMap[args[i]]; ok {
+		// After "--" every token is a positional
+		if args[i] == "--" && !terminated {
+			cfg.record(TokenTerminator, "", args[i])
+			if cfg.captureRest != "" {
+				cfg.record(TokenRest, cfg.captureRest, args[i+1:]...)
+				argsMap[cfg.captureRest] = append([]string{}, args[i+1:]...)
+				break
+			}
+			terminated = true
+			continue
+		}
+
+		// A Literal positional takes the token even if it looks like a flag, as long as it's not
+		// a registered one (e.g. "-foo"): no error or suggestion is given for it
+		literal := terminated || (posIndex < len(posArgs) && argsList[posArgs[posIndex]].(PositionalArg).Literal)
+
+		// repr is the representation matched by the token (e.g. "--no-color" for "--no")
+		repr := args[i]
+		arg, ok := reprMap[args[i]]
+		if !ok && cfg.caseInsensitive {
+			arg, ok = reprMap[strings.ToLower(args[i])]
+			ok = ok && (*arg).getOrder() == orderCommand
+		}
+		if terminated {
+			ok = false
+		} else if !ok && cfg.abbreviations {
+			var err error
+			if arg, repr, err = resolveAbbreviation(args[i], reprMap); err != nil {
+				return nil, err
+			}
+			ok = arg != nil
+		}
+		// BOOLFLAG WITH EXPLICIT VALUE (e.g. "--flag=no")
+		if eq := strings.Index(args[i], "="); !ok && !terminated && eq > 0 {
+			if arg, found := reprMap[args[i][:eq]]; found && (*arg).getOrder() == orderBoolFlag {
+				b, err := parseBoolString(args[i][eq+1:])
+				if err != nil {
+					return nil, fmt.Errorf("Error: invalid value '%s' for flag '%s' (accepted: %s)", args[i][eq+1:], args[i][:eq], strings.Join(boolStrings, ", "))
+				}
+				if w := deprecationWarning(*arg, args[i][:eq]); w != "" {
+					cfg.warnings = append(cfg.warnings, w)
+				}
+				cfg.record(TokenFlag, (*arg).GetID(), args[i])
+				argsMap[(*arg).GetID()] = b != isNegation((*arg).(BoolFlag), args[i][:eq])
+				continue
+			}
+		}
+
+		// BUNCHED SHORT FLAGS (e.g. "-vvv", "-ab" or "-ofile")
+		if !ok && !terminated {
+			if bunch, value := expandShortFlags(args[i], reprMap); bunch != nil {
+				args = append(append(append([]string{}, args[:i]...), bunch...), args[i+1:]...)
+				if value {
+					attached = i + len(bunch) - 1
+				}
+				n = len(args)
+				i--
+				continue
+			}
+		}
+
+		if ok {
+			if w := deprecationWarning(*arg, args[i]); w != "" {
+				cfg.warnings = append(cfg.warnings, w)
+			}
+			if (*arg).getOrder() == orderCommand {
+				cfg.record(TokenCommand, (*arg).GetID(), args[i])
+			} else {
+				cfg.record(TokenFlag, (*arg).GetID(), args[i])
+			}
+			start := i
+
 			switch (*arg).getOrder() {
 			// STRINGFLAG
 			case orderStringFlag:
 				flag := (*arg).(StringFlag)
+				name := args[i]
+
+				var values []string
+				if attached == i+1 {
+					// A value attached to the short flag is taken as it is (e.g. "-o-")
+					values = []string{args[i+1]}
+					i++
+				} else if flag.CommaSplit && i+1 < n && strings.Contains(args[i+1], ",") {
+					// Comma-joined values in a single token (e.g. "--coords 3,4")
+					values = strings.Split(args[i+1], ",")
+					if v := varName(flag.Vars, len(values)); len(values) < flag.NArgs && !flag.Variadic && v != "" {
+						return nil, fmt.Errorf("Error: value for '%s %s' is missing", name, v)
+					} else if len(values) != flag.NArgs && !flag.Variadic {
+						return nil, fmt.Errorf("Error: expected %d comma-separated values for flag '%s', got %d", flag.NArgs, name, len(values))
+					}
+					i++
+				} else if flag.Variadic {
+					// All the values up to the next flag or "--"
+					values = []string{}
+					for i+1 < n && args[i+1] != "--" && !isFlagToken(args[i+1], reprMap, cfg) {
+						values = append(values, args[i+1])
+						i++
+					}
+				} else if flag.AllowDashValue {
+					// The following tokens are values whatever they look like
+					if i+flag.NArgs >= n {
+						return nil, IncorrectUsageError{Flag: name, Expected: flag.NArgs, Available: n - i - 1, Var: varName(flag.Vars, n-i-1)}
+					}
+					values = append([]string{}, args[i+1:i+1+flag.NArgs]...)
+					i += flag.NArgs
+				} else {
+					var err error
+					if values, err = consumeValues(args, i, flag.NArgs, reprMap, cfg, flag.Vars...); err != nil {
+						return nil, err
+					}
+					i += flag.NArgs
+				}
 
-				if i+flag.NArgs >= n {
-					return nil, fmt.Errorf("Error: incorrect arguments number for flag '%s'", args[i])
+				if err := cfg.cancelled(); err != nil {
+					return nil, err
+				}
+				values = flag.transform(values)
+				if err := flag.validate(cfg.context(), values, name); err != nil {
+					return nil, err
 				}
+				argsMap[flag.GetID()] = values
 
-				var j int
-				var values = make([]string, flag.NArgs)
-				for j = 0; j < flag.NArgs; j++ {
-					if _, ok = reprMap[args[i+j+1]]; ok {
-						return nil, fmt.Errorf("Error: incorrect arguments number for flag '%s'", args[i])
-					}
-					values[j] = args[i+j+1]
+			// CHOICEFLAG
+			case orderChoiceFlag:
+				flag := (*arg).(ChoiceFlag)
+				values, err := consumeValues(args, i, 1, reprMap, cfg)
+				if err != nil {
+					return nil, err
 				}
-				i += j
+				if !contains(flag.Choices, values[0]) {
+					return nil, fmt.Errorf("Error: invalid value '%s' for '%s' (allowed: %s)", values[0], args[i], strings.Join(flag.Choices, ", "))
+				}
+				i++
 
 				argsMap[flag.GetID()] = values
 
+			// INTFLAG
+			case orderIntFlag:
+				flag := (*arg).(IntFlag)
+				values, err := consumeValues(args, i, flag.NArgs, reprMap, cfg)
+				if err != nil {
+					return nil, err
+				}
+				i += flag.NArgs
+
+				ints := make([]int, len(values))
+				for j, v := range values {
+					if ints[j], err = strconv.Atoi(v); err != nil {
+						return nil, fmt.Errorf("Error: value '%s' for flag '%s' is not an integer", v, args[i-flag.NArgs])
+					}
+				}
+				argsMap[flag.GetID()] = ints
+
+			// FLOATFLAG
+			case orderFloatFlag:
+				flag := (*arg).(FloatFlag)
+				values, err := consumeValues(args, i, flag.NArgs, reprMap, cfg)
+				if err != nil {
+					return nil, err
+				}
+				i += flag.NArgs
+
+				floats := make([]float64, len(values))
+				for j, v := range values {
+					if floats[j], err = strconv.ParseFloat(v, 64); err != nil {
+						return nil, fmt.Errorf("Error: value '%s' for flag '%s' is not a number", v, args[i-flag.NArgs])
+					}
+				}
+				argsMap[flag.GetID()] = floats
+
+			// DURATIONFLAG
+			case orderDurationFlag:
+				flag := (*arg).(DurationFlag)
+				values, err := consumeValues(args, i, flag.NArgs, reprMap, cfg)
+				if err != nil {
+					return nil, err
+				}
+				i += flag.NArgs
+
+				durations := make([]time.Duration, len(values))
+				for j, v := range values {
+					if durations[j], err = time.ParseDuration(v); err != nil {
+						return nil, fmt.Errorf("Error: value '%s' for '%s' is not a valid duration", v, args[i-flag.NArgs])
+					}
+				}
+				argsMap[flag.GetID()] = durations
+
 			// LISTFLAG
 			case orderListFlag:
 				flag := (*arg).(ListFlag)
@@ -148,7 +578,10 @@ func parseArgs(args []string, argsList []Argument) (map[string]interface{}, erro
 				var j int
 				var values = []string{}
 				for j = i + 1; j < n; j++ {
-					if _, ok := reprMap[args[j]]; !ok {
+					if flag.Max > 0 && len(values) == flag.Max {
+						break
+					}
+					if _, ok := reprMap[args[j]]; !ok && args[j] != "--" {
 						values = append(values, args[j])
 					} else {
 						break
@@ -161,17 +594,39 @@ func parseArgs(args []string, argsList []Argument) (map[string]interface{}, erro
 			// BOOLFLAG
 			case orderBoolFlag:
 				flag := (*arg).(BoolFlag)
-				argsMap[flag.GetID()] = true
+				argsMap[flag.GetID()] = !isNegation(flag, repr)
+
+			// COUNTFLAG
+			case orderCountFlag:
+				flag := (*arg).(CountFlag)
+				count, _ := argsMap[flag.GetID()].(int)
+				argsMap[flag.GetID()] = count + 1
 
 			// HELPFLAG
 			case orderHelpFlag:
 				argsMap = map[string]interface{}{"help": true}
 				return argsMap, nil
 
+			// VERSIONFLAG
+			case orderVersionFlag:
+				argsMap = map[string]interface{}{"version": true}
+				return argsMap, nil
+
 			// COMMAND
 			case orderCommand:
 				cmd := (*arg).(*Command)
-				cmdMap, err := cmd.parseArgs(args[i+1:])
+				if cmd.deprecated != "" {
+					cfg.warnings = append(cfg.warnings, fmt.Sprintf("Warning: command '%s' is deprecated: %s", cmd.GetID(), cmd.deprecated))
+				}
+				if cfg.stopAtCommand {
+					cfg.record(TokenRest, RestKey, args[i+1:]...)
+					argsMap[cmd.GetID()] = map[string]interface{}{}
+					argsMap[RestKey] = append([]string{}, args[i+1:]...)
+					i = n
+					break
+				}
+
+				cmdMap, err := cmd.parseArgs(args[i+1:], cfg)
 				if err != nil {
 					return nil, err
 				}
@@ -189,23 +644,106 @@ func parseArgs(args []string, argsList []Argument) (map[string]interface{}, erro
 				argsMap[cmd.GetID()] = cmdMap
 				i = n
 			}
+
+			if (*arg).getOrder() != orderCommand {
+				cfg.record(TokenValue, (*arg).GetID(), args[start+1:i+1]...)
+			}
 		} else {
+			// A mistyped command written as a long flag (e.g. "--buidl" for "build")
+			if cfg.suggestCommands && !literal && strings.HasPrefix(args[i], "--") {
+				if cmd := closestName(args[i][2:], commandNames(argsList)); cmd != "" {
+					cfg.record(TokenUnrecognized, "", args[i])
+					return nil, fmt.Errorf("Error: unknown flag '%s'; did you mean the command '%s'?", args[i], cmd)
+				}
+			}
+
+			// A command-based program doesn't take other words in place of its commands
+			if cfg.strictCommands && !literal && posIndex == 0 && !strings.HasPrefix(args[i], "-") {
+				if names := commandNames(argsList); len(names) > 0 {
+					cfg.record(TokenUnrecognized, "", args[i])
+					return nil, UnknownCommandError{Name: args[i], Available: names}
+				}
+			}
+
+			// A mistyped long flag (e.g. "--helo" for "--hello")
+			if cfg.suggestFlags && !literal && strings.HasPrefix(args[i], "--") {
+				if flag := closestName(args[i], longFlags(reprMap)); flag != "" {
+					cfg.record(TokenUnrecognized, "", args[i])
+					return nil, UnrecognizedArgError{Arg: args[i], Suggestion: flag}
+				}
+			}
+
 			// POSITIONAL ARGUMENTS
 			if len(posArgs) == posIndex {
-				return nil, fmt.Errorf("Error: unrecognized argument '%s'", args[i])
+				if cfg.captureRest != "" {
+					cfg.record(TokenRest, cfg.captureRest, args[i:]...)
+					argsMap[cfg.captureRest] = append([]string{}, args[i:]...)
+					break
+				}
+				switch cfg.extraPolicy {
+				case ExtraIgnore:
+					cfg.record(TokenExtra, "", args[i])
+					continue
+				case ExtraCollect:
+					cfg.record(TokenExtra, ExtraKey, args[i])
+					extra, _ := argsMap[ExtraKey].([]string)
+					argsMap[ExtraKey] = append(append([]string{}, extra...), args[i])
+					continue
+				}
+				cfg.record(TokenUnrecognized, "", args[i])
+				return nil, UnrecognizedArgError{Arg: args[i]}
 			}
 
 			pArg := argsList[posArgs[posIndex]].(PositionalArg)
-			argsMap[pArg.GetID()] = args[i]
+			value, err := pArg.convert(args[i])
+			if err != nil {
+				return nil, err
+			}
+			cfg.record(TokenPositional, pArg.GetID(), args[i])
+
+			// A variadic positional is the last one and takes all the remaining values
+			if pArg.Variadic {
+				values, _ := argsMap[pArg.GetID()].([]string)
+				argsMap[pArg.GetID()] = append(append([]string{}, values...), value.(string))
+				continue
+			}
+			argsMap[pArg.GetID()] = value
 			posIndex++
+
+			// The rest is captured as soon as the positionals are over, dropping a "--" right
+			// after them as if it came before
+			if cfg.captureRest != "" && posIndex == len(posArgs) {
+				rest := args[i+1:]
+				if len(rest) > 0 && rest[0] == "--" {
+					cfg.record(TokenTerminator, "", rest[0])
+					rest = rest[1:]
+				}
+				cfg.record(TokenRest, cfg.captureRest, rest...)
+				argsMap[cfg.captureRest] = append([]string{}, rest...)
+				break
+			}
 		}
 	}
 
-	// We check if any required positional argument is missing
-	// TODO: possible implementation for required flags
+	if err := applyEnv(argsMap, argsList, cfg); err != nil {
+		return nil, err
+	}
+	if err := applyLoadedDefaults(argsMap, argsList, cfg); err != nil {
+		return nil, err
+	}
+
+	applyDefaults(argsMap, argsList)
+
+	// We check if any required positional argument or flag is missing
 	for _, pos := range reqPos {
 		if !IsPresent(argsMap, pos) {
-			return nil, fmt.Errorf("Error: missing required positional argument '%s'", pos)
+			return nil, MissingPositionalError{Name: pos}
+		}
+	}
+	for _, f := range reqFlags {
+		if !IsPresent(argsMap, f.GetID()) {
+			reprs := f.Represent()
+			return nil, MissingFlagError{Flag: reprs[len(reprs)-1]}
 		}
 	}
 
@@ -217,6 +755,41 @@ func (p *ArgsParser) GenerateHelp() string {
 	return p.helpGen(p, nil)
 }
 
+// GenerateHelpDepth produces the program help followed by the help of its commands and
+// subcommands, walking the command tree only n levels deep. A note is added for the
+// commands whose subcommands are not shown
+func (p *ArgsParser) GenerateHelpDepth(n int) string {
+	help := p.GenerateHelp()
+	if n <= 0 {
+		if len(commandsOf(p.argsList)) > 0 {
+			help += "\n(commands not shown)\n"
+		}
+		return help
+	}
+
+	for _, c := range commandsOf(p.argsList) {
+		help += commandTreeHelp(c, c.GetID(), 1, n)
+	}
+	return help
+}
+
+func commandTreeHelp(c *Command, path string, depth, maxDepth int) string {
+	help := fmt.Sprintf("\nCommand: %s\n%s", path, c.GenerateHelp())
+
+	subcommands := commandsOf(c.argsList)
+	if depth == maxDepth {
+		if len(subcommands) > 0 {
+			help += fmt.Sprintf("(subcommands of '%s' not shown)\n", path)
+		}
+		return help
+	}
+
+	for _, sc := range subcommands {
+		help += commandTreeHelp(sc, path+" "+sc.GetID(), depth+1, maxDepth)
+	}
+	return help
+}
+
 // GenerateCommandHelp produces the help string for a Command to be shown when the "-h" or "--help" flags are inserted by the user.
 func (p *ArgsParser) GenerateCommandHelp(cmdTrace []*Command) string {
 	return p.helpGen(p, cmdTrace)
@@ -228,134 +801,686 @@ func (p *ArgsParser) SetHelpGenerator(h HelpMessageGenerator) {
 	p.helpGen = h
 }
 
-// SetHelpFlagMessage accepts a string to be used in the program help with that HelpFlag
-func (p *ArgsParser) SetHelpFlagMessage(m string) {
-	for i, a := range p.argsList {
-		if a.getOrder() == orderHelpFlag {
-			p.argsList[i] = HelpFlag{Help: m}
-			return
-		}
-	}
+// SetTraceFormatter accepts a function to be used to render the command path in the
+// "Reference:" line of the default command help
+func (p *ArgsParser) SetTraceFormatter(f TraceFormatter) {
+	p.traceFmt = f
 }
 
-// PrintHelp shows the complete help message for the program
-func (p *ArgsParser) PrintHelp() {
-	help := p.helpGen(p, nil)
-	fmt.Println(help)
+// SetHelpWidth sets the width within which the descriptions of the default help messages are
+// word-wrapped, for both the program and its commands. With 0 (default) the terminal width is
+// taken from $COLUMNS, falling back to 80; a negative width disables the wrapping
+func (p *ArgsParser) SetHelpWidth(width int) {
+	p.helpWidth = width
+	setCommandsHelpWidth(p.argsList, width)
 }
 
-// PrintCommandHelp shows the complete help message for a program command
-func (p *ArgsParser) PrintCommandHelp(cmdTrace []*Command) {
-	help := p.helpGen(p, cmdTrace)
-	fmt.Println(help)
+// setCommandsHelpWidth propagates the help width to all the commands of the list
+func setCommandsHelpWidth(argsList []Argument, width int) {
+	for _, c := range commandsOf(argsList) {
+		c.helpWidth = width
+		setCommandsHelpWidth(c.argsList, width)
+	}
 }
 
-// ReportError prints the passed error's message, shows the correct usage and quits
-func (p *ArgsParser) ReportError(err error) {
-	fmt.Printf("%s\n\n", err.Error())
-	p.PrintHelp()
-	os.Exit(0)
+// SetHelpHeaderTemplate accepts a string replacing the name and description at the top of
+// the default help. The placeholders {{.Name}}, {{.Description}} and {{.Version}} are expanded
+// with the parser fields ({{.Version}} is empty until SetVersion is called)
+func (p *ArgsParser) SetHelpHeaderTemplate(t string) {
+	p.helpHeader = t
 }
 
-// Parse function returns a map with argument values
-func (p *ArgsParser) Parse() (map[string]interface{}, error) {
-	p.SortArgsList()
-	argsMap, err := parseArgs(os.Args[1:], p.argsList)
+// SetHelpFooterTemplate accepts a string to be shown at the bottom of the default help.
+// The same placeholders of SetHelpHeaderTemplate are expanded
+func (p *ArgsParser) SetHelpFooterTemplate(t string) {
+	p.helpFooter = t
+}
+
+// expandTemplate fills the help templates with the program metadata. If the template
+// is malformed, it is returned as it is
+func (p *ArgsParser) expandTemplate(t string) string {
+	tmpl, err := template.New("help").Parse(t)
 	if err != nil {
-		placeholder := "[*]"
-		errorString := err.Error()
-		if strings.Contains(errorString, placeholder) {
-			errorString = strings.Replace(errorString, placeholder, "", 1)
-		}
-		return nil, fmt.Errorf(errorString)
+		return t
 	}
 
-	if GetBool(argsMap, "help") {
-		if !IsPresent(argsMap, "trace") {
-			p.PrintHelp()
-		} else {
-			cmdTrace := argsMap["trace"].([]*Command)
-			p.PrintCommandHelp(cmdTrace)
-		}
-		os.Exit(0)
+	data := struct{ Name, Description, Version string }{p.Name, p.Description, p.version}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return t
 	}
+	return b.String()
+}
 
-	return argsMap, nil
+// AddExample inserts a usage example to be shown in the program help (e.g. "prog -v input.txt")
+func (p *ArgsParser) AddExample(example string) {
+	p.examples = append(p.examples, example)
 }
 
-// NewStringFlag checks the fields for consistency and inserts the new flag
-func (p *ArgsParser) NewStringFlag(f StringFlag) error {
-	if f.Name == "" && f.Short == "" {
-		return fmt.Errorf("Error: at least one identifier must be specified")
+// SetExamplesPosition tells where the examples are shown in the default program help
+func (p *ArgsParser) SetExamplesPosition(pos ExamplesPosition) {
+	p.examplesPos = pos
+}
+
+// examplesHelp produces the examples section of the help (empty if there are no examples)
+func (p *ArgsParser) examplesHelp() string {
+	if len(p.examples) == 0 {
+		return ""
 	}
 
-	if f.NArgs < 1 {
-		f.NArgs = 1
+	help := "\nExamples:\n"
+	for _, e := range p.examples {
+		help += fmt.Sprintf("  %s\n", e)
 	}
+	return help
+}
 
-	if len(f.Vars) < f.NArgs {
-		for len(f.Vars) < f.NArgs {
-			f.Vars = append(f.Vars, "value")
+// SetVersion sets the program version and adds the "-V" and "--version" flags, which print
+// the program name and version and quit (see SetExitOnHelp). An error is returned if the
+// flags collide with the ones already inserted
+func (p *ArgsParser) SetVersion(version string) error {
+	for _, a := range p.argsList {
+		if a.getOrder() == orderVersionFlag {
+			p.version = version
+			return nil
 		}
-	} else if len(f.Vars) > f.NArgs {
-		return fmt.Errorf("Error: too many value names specified (expected %d, got %d)", f.NArgs, len(f.Vars))
 	}
 
-	err := checkIdentifiers(&p.argsList, f)
-	if err != nil {
+	f := VersionFlag{Help: "shows program version and exits"}
+	if err := p.index.insert(&p.argsList, f); err != nil {
 		return err
 	}
-
-	p.argsList = append(p.argsList, f)
+	p.version = version
 	return nil
 }
 
-// NewListFlag checks the fields for consistency and inserts the new flag
-func (p *ArgsParser) NewListFlag(f ListFlag) error {
-	if f.Name == "" && f.Short == "" {
-		return fmt.Errorf("Error: at least one identifier must be specified")
-	}
-	if f.Var == "" {
-		f.Var = "value"
+// DisableHelpFlag removes the "-h" and "--help" flags of the program, which are then treated
+// as any other unregistered argument, and frees the "help" identifier. The help flags of the
+// commands are not affected and GenerateHelp or PrintHelp can still be called manually
+func (p *ArgsParser) DisableHelpFlag() {
+	for i, a := range p.argsList {
+		if a.getOrder() == orderHelpFlag {
+			p.argsList = append(p.argsList[:i], p.argsList[i+1:]...)
+			p.index.reset()
+			break
+		}
 	}
+	p.noHelpFlag = true
+}
 
-	err := checkIdentifiers(&p.argsList, f)
-	if err != nil {
-		return err
+// SetHelpFlagMessage accepts a string to be used in the program help with that HelpFlag
+func (p *ArgsParser) SetHelpFlagMessage(m string) {
+	for i, a := range p.argsList {
+		if f, ok := a.(HelpFlag); ok {
+			f.Help = m
+			p.argsList[i] = f
+			return
+		}
 	}
+}
 
-	p.argsList = append(p.argsList, f)
-	return nil
+// SetHelpFlagReps replaces the representations of the help flag (e.g. "?" and "usage" for
+// "-?" and "--usage"), which is still stored in the map as "help". An empty string keeps the
+// default one. An error is returned if the new representations collide with other flags or
+// if the help flag is disabled
+func (p *ArgsParser) SetHelpFlagReps(short, long string) error {
+	return setHelpFlagReps(&p.argsList, p.index, short, long)
 }
 
-// NewBoolFlag checks the flag representations and inserts the new flag
-func (p *ArgsParser) NewBoolFlag(f BoolFlag) error {
-	if f.Name == "" && f.Short == "" {
-		return fmt.Errorf("Error: at least one identifier must be specified")
+// SetVersionFlagReps replaces the representations of the version flag (e.g. "v" for "-v"),
+// which is still stored in the map as "version". An empty string keeps the default one.
+// An error is returned if the new representations collide with other flags or if the
+// version flag is not set (see SetVersion)
+func (p *ArgsParser) SetVersionFlagReps(short, long string) error {
+	for i, a := range p.argsList {
+		if f, ok := a.(VersionFlag); ok {
+			f.Short, f.Long = short, long
+			return replaceArg(&p.argsList, p.index, i, f)
+		}
+	}
+	return fmt.Errorf("Error: the version flag is not set")
+}
+
+// PrintHelp shows the complete help message for the program
+func (p *ArgsParser) PrintHelp() {
+	help := p.helpGen(p, nil)
+	fmt.Fprintln(p.output(), help)
+}
+
+// PrintCommandHelp shows the complete help message for a program command
+func (p *ArgsParser) PrintCommandHelp(cmdTrace []*Command) {
+	help := p.helpGen(p, cmdTrace)
+	fmt.Fprintln(p.output(), help)
+}
+
+// SetOutput sets the writer used to show the help and the version (default is os.Stdout)
+func (p *ArgsParser) SetOutput(w io.Writer) {
+	p.out = w
+}
+
+// SetErrOutput sets the writer used by ReportError (default is os.Stderr)
+func (p *ArgsParser) SetErrOutput(w io.Writer) {
+	p.errOut = w
+}
+
+func (p *ArgsParser) output() io.Writer {
+	if p.out == nil {
+		return os.Stdout
+	}
+	return p.out
+}
+
+func (p *ArgsParser) errOutput() io.Writer {
+	if p.errOut == nil {
+		return os.Stderr
+	}
+	return p.errOut
+}
+
+// SetReportHelpOnError tells whether ReportError should show the help message after the
+// error (default is true). If false, only the error message is printed
+func (p *ArgsParser) SetReportHelpOnError(b bool) {
+	p.quietErrors = !b
+}
+
+// SetExitOnError tells whether ReportError should quit the program after reporting the
+// error (default is true). If false, ReportError just returns
+func (p *ArgsParser) SetExitOnError(b bool) {
+	p.noExitOnErr = !b
+}
+
+// SetExitOnHelp tells whether the program should quit after showing the help when the help
+// flag is inserted (default is true). If false, nothing is printed and the parsing functions
+// return ErrHelpRequested along with the map, which holds the command "trace" if any.
+// The same applies to the version flag, returning ErrVersionRequested
+func (p *ArgsParser) SetExitOnHelp(b bool) {
+	p.noExitOnHlp = !b
+}
+
+// ReportError prints the passed error's message, shows the correct usage and quits.
+// The message is written to the error output (see SetErrOutput)
+func (p *ArgsParser) ReportError(err error) {
+	w := p.errOutput()
+	if p.quietErrors {
+		fmt.Fprintln(w, err.Error())
+	} else {
+		fmt.Fprintf(w, "%s\n\n", err.Error())
+		fmt.Fprintln(w, p.helpGen(p, nil))
+	}
+
+	if !p.noExitOnErr {
+		os.Exit(0)
+	}
+}
+
+// SetMultiCall enables the busybox-style invocation: if the base name of the executable
+// (os.Args[0]) matches a command name, that command is implicitly invoked
+func (p *ArgsParser) SetMultiCall(b bool) {
+	p.multiCall = b
+}
+
+// SetStopAtCommand makes the parser stop when a command is found: the command is stored in
+// the map with an empty map, while the following arguments are left unparsed and stored
+// under the RestKey as a slice of strings for custom dispatching
+func (p *ArgsParser) SetStopAtCommand(b bool) {
+	p.config.stopAtCommand = b
+}
+
+// SetCaptureRest makes the program store the tokens following its positionals verbatim under
+// the given key, as a slice of strings (e.g. "exec <cmd> args..."). Once all the positionals
+// are assigned, or after "--", the remaining tokens are not interpreted at all, even if they
+// look like flags. Without positionals, the capture starts at the first token which is not a
+// flag nor a command. An error is returned if the key is already an identifier
+func (p *ArgsParser) SetCaptureRest(key string) error {
+	if findArg(p.argsList, key) != nil {
+		return fmt.Errorf("Error: identifier '%s' already exists", key)
+	}
+	p.config.captureRest = key
+	return nil
+}
+
+// SetExtraPositionalPolicy tells what to do with the tokens which are neither flags nor commands
+// and exceed the positionals, including unknown flags: ExtraError (default) fails with an
+// UnrecognizedArgError, ExtraIgnore skips them and ExtraCollect stores them in order under the
+// ExtraKey as a slice of strings, e.g. to forward them to another program. The policy also
+// applies to the commands, whose extra tokens are stored in their own maps: since a command
+// takes all the tokens following it, the extra tokens after a command name belong to it
+func (p *ArgsParser) SetExtraPositionalPolicy(policy ExtraPositionalPolicy) {
+	p.config.extraPolicy = policy
+}
+
+// SetAbbreviations enables the resolution of unique prefixes: a token starting with "--"
+// can abbreviate a long flag or a command name (e.g. "--verb" for "--verbose"), while a
+// token without dashes can abbreviate only a command name. If the prefix matches more
+// arguments, an error listing all the candidates is returned
+func (p *ArgsParser) SetAbbreviations(b bool) {
+	p.config.abbreviations = b
+}
+
+// SetSuggestCommands makes the parser check whether an unknown long flag resembles a
+// command name (e.g. "--buidl" for "build"): if so, an error suggesting the command is
+// returned instead of treating the token as a positional or an unrecognized argument
+func (p *ArgsParser) SetSuggestCommands(b bool) {
+	p.config.suggestCommands = b
+}
+
+// SetSuggestions makes the parser check whether an unknown long flag is a typo of an existing
+// one (e.g. "--helo" for "--hello"): if so, an UnrecognizedArgError suggesting the flag is
+// returned instead of treating the token as a positional or an unrecognized argument
+func (p *ArgsParser) SetSuggestions(b bool) {
+	p.config.suggestFlags = b
+}
+
+// SetCaseInsensitiveCommands makes the command names match regardless of the case of the
+// user input (e.g. "Run", "RUN" and "run"). The map still uses the registered names, while
+// flags remain case-sensitive
+func (p *ArgsParser) SetCaseInsensitiveCommands(b bool) {
+	p.config.caseInsensitive = b
+}
+
+// SetStrictFlagValues makes parsing fail when a flag expecting values is followed by another
+// flag (e.g. "--hello --spanish"), also recognizing abbreviated and bunched short flags.
+// Negative numbers are still taken as values
+func (p *ArgsParser) SetStrictFlagValues(b bool) {
+	p.config.strictValues = b
+}
+
+// SetStrictCommands is meant for command-based programs: if the first token which is not a
+// flag doesn't match any command, an UnknownCommandError listing the available commands is
+// returned instead of taking the token as a positional. It also applies to the subcommands
+func (p *ArgsParser) SetStrictCommands(b bool) {
+	p.config.strictCommands = b
+}
+
+// SetSingleDashLong makes the long flags respond to a single dash too (e.g. "-output" for
+// "--output"), as in some legacy tools. A short flag with the same representation keeps the
+// precedence (e.g. "-o" for a flag with Short "o" and another one with Name "o"), and a
+// single-dash long flag is preferred to the bunching of short flags (e.g. "-ab" for a flag
+// named "ab" and two BoolFlags "a" and "b")
+func (p *ArgsParser) SetSingleDashLong(b bool) {
+	p.config.singleDashLong = b
+}
+
+// SetEnvPrefix enables reading the flags not inserted by the user from environment variables
+// named after the prefix, the command path and the flag identifier. For example, with the
+// "MYTOOL" prefix, the flag "host" is read from MYTOOL_HOST and the same flag inside the
+// command "db" is read from MYTOOL_DB_HOST (dashes become underscores).
+// StringFlag, IntFlag and ListFlag values are separated by spaces, BoolFlags accept the same
+// values as "--flag=value". Variables set to an empty string are ignored. The Env field of a
+// flag takes precedence over the prefix
+func (p *ArgsParser) SetEnvPrefix(prefix string) {
+	p.config.envPrefix = prefix
+}
+
+// Parse function returns a map with argument values
+func (p *ArgsParser) Parse() (map[string]interface{}, error) {
+	args := os.Args[1:]
+	if p.multiCall && len(os.Args) > 0 {
+		if base := filepath.Base(os.Args[0]); contains(p.CommandNames(), base) {
+			args = append([]string{base}, args...)
+		}
 	}
 
-	err := checkIdentifiers(&p.argsList, f)
+	return p.ParseContext(context.Background(), args)
+}
+
+// AddRequires makes the flag depend on other flags: if the flag is present in the parsed map
+// while one of its dependencies is absent, parsing fails (e.g. "--cert" requires "--key").
+// The identifiers of the flags are expected. Multiple calls add further dependencies
+func (p *ArgsParser) AddRequires(flag string, deps ...string) error {
+	reqs, err := newRequirements(p.argsList, flag, deps)
 	if err != nil {
 		return err
 	}
+	p.requires = append(p.requires, reqs...)
+	return nil
+}
 
-	p.argsList = append(p.argsList, f)
+// Require declares arguments which must be present in the parsed map, whatever their type:
+// parsing fails with a MissingArgumentError for the first absent one. The checks run after the
+// ones of the Required fields, and the identifiers of the arguments are expected (values
+// coming from defaults and environment variables count as present)
+func (p *ArgsParser) Require(keys ...string) error {
+	for _, key := range keys {
+		if findArg(p.argsList, key) == nil {
+			return fmt.Errorf("Error: identifier '%s' not found", key)
+		}
+	}
+	p.required = append(p.required, keys...)
 	return nil
 }
 
+// SetArgsPreprocessor accepts a function receiving the raw arguments before parsing, which
+// can inject or rewrite tokens (e.g. expanding files or renaming deprecated flags).
+// If it returns an error, parsing is aborted
+func (p *ArgsParser) SetArgsPreprocessor(f ArgsPreprocessor) {
+	if f == nil {
+		p.preprocess = nil
+		return
+	}
+	p.preprocess = func(_ context.Context, args []string) ([]string, error) {
+		return f(args)
+	}
+}
+
+// SetArgsPreprocessorContext works like SetArgsPreprocessor, but the function also gets the
+// context of the parsing, so that it can stop its work once the context is done
+func (p *ArgsParser) SetArgsPreprocessorContext(f ArgsPreprocessorContext) {
+	p.preprocess = f
+}
+
+// SetHandler accepts a function to be run by Execute when no command is invoked
+func (p *ArgsParser) SetHandler(h CommandHandler) {
+	p.handler = h
+}
+
+// Execute walks the commands of the parsed map and runs the handler of the deepest invoked
+// one with its own argument map. If no command is invoked, the handler of the parser is run
+// with the whole map (nothing is done if it is not set). An error is returned if the
+// deepest command has no handler, otherwise the error of the handler is returned
+func (p *ArgsParser) Execute(aMap map[string]interface{}) error {
+	argsList, argsMap, handler := p.argsList, aMap, p.handler
+	var cmd *Command
+	for next := invokedCommand(argsList, argsMap); next != nil; next = invokedCommand(argsList, argsMap) {
+		cmd = next
+		argsMap, _ = GetSubMap(argsMap, cmd.GetID())
+		argsList, handler = cmd.argsList, cmd.handler
+	}
+
+	if handler == nil {
+		if cmd == nil {
+			return nil
+		}
+		return fmt.Errorf("Error: no handler for command '%s'", cmd.GetID())
+	}
+	return handler(argsMap)
+}
+
+// MustParse works like Parse, but there's no error to be checked: if parsing fails, the
+// error is reported with ReportError, which quits the program. If the parser is set not to
+// exit on errors, MustParse panics with the error instead. The help and version flags are
+// handled as in Parse: the program quits after showing them, unless SetExitOnHelp(false)
+// is called, in which case the map is returned as it is
+func (p *ArgsParser) MustParse() map[string]interface{} {
+	argsMap, err := p.Parse()
+	if err != nil && err != ErrHelpRequested && err != ErrVersionRequested {
+		p.ReportError(err)
+		panic(err)
+	}
+	return argsMap
+}
+
+// ParseFrom works like Parse, but parses the passed arguments (without the program name)
+// instead of os.Args. Useful for tests, embedded shells or any other source of arguments
+func (p *ArgsParser) ParseFrom(args []string) (map[string]interface{}, error) {
+	return p.ParseContext(context.Background(), args)
+}
+
+// ParseContext works like ParseFrom, but the parsing can be cancelled through the context:
+// the steps which may run user code or I/O (response files, preprocessor, Transform and
+// Validate functions) are not started once the context is done, and ctx.Err() is returned.
+// The context is also passed to the hooks which accept it, so that they can stop their work
+// (see SetArgsPreprocessorContext and the ValidateContext field of StringFlag)
+func (p *ArgsParser) ParseContext(ctx context.Context, args []string) (map[string]interface{}, error) {
+	return p.parse(p.callConfig(ctx), args, nil)
+}
+
+// ParseWithDefaults parses the passed arguments (without the program name) seeding the
+// returned map with the presets: the values inserted by the user override them
+func (p *ArgsParser) ParseWithDefaults(args []string, presets map[string]interface{}) (map[string]interface{}, error) {
+	return p.parse(p.callConfig(context.Background()), args, presets)
+}
+
+// ParseGlobal parses only the program arguments up to the first command (without the
+// program name), returning them along with the command name and the following raw arguments.
+// This allows a two-phase dispatching where the command arguments are handled separately.
+// The program arguments get their defaults and are checked as in ParseFrom (e.g. a missing
+// required flag is an error). The command is an empty string if none is found
+func (p *ArgsParser) ParseGlobal(args []string) (map[string]interface{}, string, []string, error) {
+	cfg := p.callConfig(context.Background())
+	cfg.stopAtCommand = true
+
+	globals, err := p.parse(cfg, args, nil)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	rest, ok := globals[RestKey].([]string)
+	if !ok {
+		return globals, "", []string{}, nil
+	}
+	delete(globals, RestKey)
+
+	command, _, _ := p.GetCommandMap(globals)
+	delete(globals, command)
+	return globals, command, rest, nil
+}
+
+// callConfig returns a copy of the parser options for a single parsing, so that its state
+// (context, warnings, etc.) is not shared with other parsings
+func (p *ArgsParser) callConfig(ctx context.Context) *parseConfig {
+	cfg := p.config
+	cfg.ctx = ctx
+	cfg.cmdPath = nil
+	cfg.warnings = nil
+	return &cfg
+}
+
+func (p *ArgsParser) parse(cfg *parseConfig, args []string, presets map[string]interface{}) (map[string]interface{}, error) {
+	ctx := cfg.context()
+	defer func() { p.config.warnings = cfg.warnings }()
+
+	var err error
+	if p.respPrefix != 0 {
+		if args, err = expandResponseFiles(ctx, args, p.respPrefix, 0); err != nil {
+			return nil, err
+		}
+	}
+	if p.preprocess != nil {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		if args, err = p.preprocess(ctx, append([]string{}, args...)); err != nil {
+			return nil, err
+		}
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p.SortArgsList()
+	argsMap, err := parseArgs(args, p.argsList, cfg, presets)
+	if err != nil {
+		return nil, err
+	}
+	if p.printWarns && cfg.explain == nil {
+		for _, w := range cfg.warnings {
+			fmt.Fprintln(p.errOutput(), w)
+		}
+	}
+
+	// Without the HelpFlag, "help" may be a user argument: only command help is handled
+	help := GetBool(argsMap, "help") && (!p.noHelpFlag || IsPresent(argsMap, "trace"))
+	version := p.version != "" && GetBool(argsMap, "version")
+	if !help && !version {
+		if err = checkRequires(argsMap, p.argsList, p.requires); err != nil {
+			return nil, err
+		}
+		if err = checkRequired(argsMap, p.required); err != nil {
+			return nil, err
+		}
+	}
+
+	if help {
+		if p.noExitOnHlp || cfg.explain != nil {
+			return argsMap, ErrHelpRequested
+		}
+
+		if !IsPresent(argsMap, "trace") {
+			p.PrintHelp()
+		} else {
+			cmdTrace := argsMap["trace"].([]*Command)
+			p.PrintCommandHelp(cmdTrace)
+		}
+		os.Exit(0)
+	}
+
+	if version {
+		if p.noExitOnHlp || cfg.explain != nil {
+			return argsMap, ErrVersionRequested
+		}
+
+		fmt.Fprintf(p.output(), "%s %s\n", p.Name, p.version)
+		os.Exit(0)
+	}
+
+	return argsMap, nil
+}
+
+// Warnings returns the warnings collected during the last parsing (e.g. usage of deprecated
+// commands and flags)
+func (p *ArgsParser) Warnings() []string {
+	return append([]string{}, p.config.warnings...)
+}
+
+// SetPrintWarnings makes the parser write the warnings collected while parsing to the error
+// writer (see SetErrOutput), besides returning them with Warnings
+func (p *ArgsParser) SetPrintWarnings(b bool) {
+	p.printWarns = b
+}
+
+// NewStringFlag checks the fields for consistency and inserts the new flag
+func (p *ArgsParser) NewStringFlag(f StringFlag) error {
+	if f.Name == "" && f.Short == "" {
+		return fmt.Errorf("Error: at least one identifier must be specified")
+	}
+
+	if f.Variadic && (f.NArgs > 1 || f.AllowDashValue) {
+		return fmt.Errorf("Error: a variadic flag can't have NArgs or AllowDashValue")
+	}
+
+	if f.NArgs < 1 {
+		f.NArgs = 1
+	}
+
+	f.named = len(f.Vars)
+	if len(f.Vars) < f.NArgs {
+		for len(f.Vars) < f.NArgs {
+			f.Vars = append(f.Vars, "value")
+		}
+	} else if len(f.Vars) > f.NArgs {
+		return fmt.Errorf("Error: too many value names specified (expected %d, got %d)", f.NArgs, len(f.Vars))
+	}
+
+	return p.index.insert(&p.argsList, f)
+}
+
+// NewChoiceFlag checks the fields for consistency and inserts the new flag
+func (p *ArgsParser) NewChoiceFlag(f ChoiceFlag) error {
+	if f.Name == "" && f.Short == "" {
+		return fmt.Errorf("Error: at least one identifier must be specified")
+	}
+	if len(f.Choices) == 0 {
+		return fmt.Errorf("Error: at least one choice must be specified")
+	}
+
+	return p.index.insert(&p.argsList, f)
+}
+
+// NewIntFlag checks the fields for consistency and inserts the new flag
+func (p *ArgsParser) NewIntFlag(f IntFlag) error {
+	if f.Name == "" && f.Short == "" {
+		return fmt.Errorf("Error: at least one identifier must be specified")
+	}
+
+	if f.NArgs < 1 {
+		f.NArgs = 1
+	}
+	if f.Default != nil && len(f.Default) != f.NArgs {
+		return fmt.Errorf("Error: wrong number of default values (expected %d, got %d)", f.NArgs, len(f.Default))
+	}
+
+	return p.index.insert(&p.argsList, f)
+}
+
+// NewFloatFlag checks the fields for consistency and inserts the new flag
+func (p *ArgsParser) NewFloatFlag(f FloatFlag) error {
+	if f.Name == "" && f.Short == "" {
+		return fmt.Errorf("Error: at least one identifier must be specified")
+	}
+
+	if f.NArgs < 1 {
+		f.NArgs = 1
+	}
+	if f.Default != nil && len(f.Default) != f.NArgs {
+		return fmt.Errorf("Error: wrong number of default values (expected %d, got %d)", f.NArgs, len(f.Default))
+	}
+
+	return p.index.insert(&p.argsList, f)
+}
+
+// NewDurationFlag checks the fields for consistency and inserts the new flag
+func (p *ArgsParser) NewDurationFlag(f DurationFlag) error {
+	if f.Name == "" && f.Short == "" {
+		return fmt.Errorf("Error: at least one identifier must be specified")
+	}
+
+	if f.NArgs < 1 {
+		f.NArgs = 1
+	}
+	if f.Default != nil && len(f.Default) != f.NArgs {
+		return fmt.Errorf("Error: wrong number of default values (expected %d, got %d)", f.NArgs, len(f.Default))
+	}
+
+	return p.index.insert(&p.argsList, f)
+}
+
+// NewListFlag checks the fields for consistency and inserts the new flag
+func (p *ArgsParser) NewListFlag(f ListFlag) error {
+	if f.Name == "" && f.Short == "" {
+		return fmt.Errorf("Error: at least one identifier must be specified")
+	}
+	if f.Var == "" {
+		f.Var = "value"
+	}
+	if f.Max < 0 {
+		return fmt.Errorf("Error: the maximum number of values must not be negative")
+	}
+
+	return p.index.insert(&p.argsList, f)
+}
+
+// NewBoolFlag checks the flag representations and inserts the new flag
+func (p *ArgsParser) NewBoolFlag(f BoolFlag) error {
+	if f.Name == "" && f.Short == "" {
+		return fmt.Errorf("Error: at least one identifier must be specified")
+	}
+	if f.Negatable && f.Name == "" {
+		return fmt.Errorf("Error: a negatable flag must have a Name")
+	}
+
+	return p.index.insert(&p.argsList, f)
+}
+
+// NewCountFlag checks the fields for consistency and inserts the new flag
+func (p *ArgsParser) NewCountFlag(f CountFlag) error {
+	if f.Name == "" && f.Short == "" {
+		return fmt.Errorf("Error: at least one identifier must be specified")
+	}
+
+	return p.index.insert(&p.argsList, f)
+}
+
 // NewPositionalArg checks the argument identifier and inserts it
 func (p *ArgsParser) NewPositionalArg(a PositionalArg) error {
 	if a.Name == "" {
 		return fmt.Errorf("Error: unspecified argument name")
 	}
 
-	err := checkIdentifiers(&p.argsList, a)
-	if err != nil {
-		return err
-	}
-
-	p.argsList = append(p.argsList, a)
-	return nil
+	a.inserted = p.index.next()
+	return p.index.insert(&p.argsList, a, func(argsList []Argument) error {
+		return checkVariadic(argsList, a)
+	})
 }
 
 // NewCommand checks the argument identifier and inserts it
@@ -365,18 +1490,19 @@ func (p *ArgsParser) NewCommand(param CommandParams) (*Command, error) {
 	}
 
 	c := &Command{
-		name:     param.Name,
-		Help:     param.Help,
-		argsList: []Argument{HelpFlag{"shows command help and exits"}},
-		helpGen:  DefaultCommandHelp,
+		name:       param.Name,
+		Help:       param.Help,
+		argsList:   []Argument{HelpFlag{Help: "shows command help and exits"}},
+		index:      &argsIndex{},
+		helpGen:    DefaultCommandHelp,
+		deprecated: param.Deprecated,
+		aliases:    append([]string{}, param.Aliases...),
+		helpWidth:  p.helpWidth,
 	}
 
-	err := checkIdentifiers(&p.argsList, c)
-	if err != nil {
+	if err := p.index.insert(&p.argsList, c); err != nil {
 		return nil, err
 	}
-
-	p.argsList = append(p.argsList, c)
 	return c, nil
 }
 
@@ -393,10 +1519,16 @@ func (p *ArgsParser) NewCommand(param CommandParams) (*Command, error) {
 //      1. PositionalArg (required)
 //      2. PositionalArg (optional)
 //      3. StringFlag
-//		4. ListFlag
-//      5. BoolFlag
-//      6. HelpFlag
-//		7. Commands
+//      4. ChoiceFlag
+//      5. IntFlag
+//      6. FloatFlag
+//      7. DurationFlag
+//		8. ListFlag
+//      9. BoolFlag
+//      10. CountFlag
+//      11. HelpFlag
+//      12. VersionFlag
+//		13. Commands
 func (p *ArgsParser) SortArgsList() {
 	sort.Slice(p.argsList, func(i, j int) bool {
 		return p.argsList[i].getOrder() < p.argsList[j].getOrder()
@@ -410,26 +1542,930 @@ func (p *ArgsParser) GetArgsList() []Argument {
 	return arr
 }
 
-/************************************************************/
-func contains(arr []string, val string) bool {
-	for _, v := range arr {
-		if v == val {
-			return true
-		}
-	}
-	return false
+// Clone returns a deep copy of the parser: the arguments and the command trees of the two
+// parsers can be modified independently. Parsing only alters the parser by sorting its
+// arguments and recording the warnings (see Warnings), so the same parser can be used for
+// several parses in a row, while concurrent parses need a clone each. The clone starts with
+// a copy of the warnings of the last parsing
+func (p *ArgsParser) Clone() *ArgsParser {
+	clone := *p
+	clone.argsList = cloneArgs(p.argsList)
+	clone.index = p.index.clone()
+	clone.requires = append([]requirement{}, p.requires...)
+	clone.required = append([]string{}, p.required...)
+	clone.examples = append([]string{}, p.examples...)
+	clone.config.warnings = append([]string{}, p.config.warnings...)
+	return &clone
 }
 
-func checkIdentifiers(argsList *[]Argument, b Argument) error {
-	for _, a := range *argsList {
-		if a.GetID() == b.GetID() {
-			return fmt.Errorf("Error: identifier '%s' already exists", b.GetID())
+// cloneArgs copies the list of arguments, cloning its commands
+func cloneArgs(argsList []Argument) []Argument {
+	arr := make([]Argument, len(argsList))
+	for i, a := range argsList {
+		if c, ok := a.(*Command); ok {
+			a = c.clone()
+		}
+		arr[i] = a
+	}
+	return arr
+}
+
+// UsageLine produces the conventional one-line usage of the program from its sorted arguments:
+// the program name, the positionals (optional ones in brackets), a "[flags]" token if there
+// are flags and a "<command>" token if there are commands (e.g. "prog req [opt] [flags] <command>")
+func (p *ArgsParser) UsageLine() string {
+	p.SortArgsList()
+	parts := []string{p.Name}
+	flags, commands := false, false
+	for _, a := range p.argsList {
+		switch a.getOrder() {
+		case orderPositionalReq, orderPositionalOpt:
+			parts = append(parts, a.(PositionalArg).MetaArg())
+		case orderCommand:
+			commands = true
+		default:
+			flags = true
+		}
+	}
+
+	if flags {
+		parts = append(parts, "[flags]")
+	}
+	if commands {
+		parts = append(parts, "<command>")
+	}
+	return strings.Join(parts, " ")
+}
+
+// ArgsByType holds the arguments of a parser or a command separated by type, sorted as in
+// the help message. The built-in help and version flags are not included
+type ArgsByType struct {
+	StringFlags   []StringFlag
+	ChoiceFlags   []ChoiceFlag
+	IntFlags      []IntFlag
+	FloatFlags    []FloatFlag
+	DurationFlags []DurationFlag
+	ListFlags     []ListFlag
+	BoolFlags     []BoolFlag
+	CountFlags    []CountFlag
+	Positionals   []PositionalArg
+	Commands      []*Command
+}
+
+// FlagsByType returns the arguments of the program separated by type, so that custom help
+// generators can group them without a type switch
+func (p *ArgsParser) FlagsByType() ArgsByType {
+	p.SortArgsList()
+	return groupArgs(p.argsList)
+}
+
+// groupArgs separates the arguments of the list by type
+func groupArgs(argsList []Argument) ArgsByType {
+	g := ArgsByType{}
+	for _, a := range argsList {
+		switch v := a.(type) {
+		case StringFlag:
+			g.StringFlags = append(g.StringFlags, v)
+		case ChoiceFlag:
+			g.ChoiceFlags = append(g.ChoiceFlags, v)
+		case IntFlag:
+			g.IntFlags = append(g.IntFlags, v)
+		case FloatFlag:
+			g.FloatFlags = append(g.FloatFlags, v)
+		case DurationFlag:
+			g.DurationFlags = append(g.DurationFlags, v)
+		case ListFlag:
+			g.ListFlags = append(g.ListFlags, v)
+		case BoolFlag:
+			g.BoolFlags = append(g.BoolFlags, v)
+		case CountFlag:
+			g.CountFlags = append(g.CountFlags, v)
+		case PositionalArg:
+			g.Positionals = append(g.Positionals, v)
+		case *Command:
+			g.Commands = append(g.Commands, v)
+		}
+	}
+	return g
+}
+
+// EffectiveConfig flattens the values of the parsed map into a string representation for
+// each argument of the program: lists are comma-joined and BoolFlags are always reported as
+// "true" or "false". Commands are skipped
+func (p *ArgsParser) EffectiveConfig(aMap map[string]interface{}) map[string]string {
+	config := make(map[string]string)
+	for _, a := range p.argsList {
+		switch a.getOrder() {
+		case orderHelpFlag, orderVersionFlag, orderCommand:
+			continue
+		case orderBoolFlag:
+			config[a.GetID()] = fmt.Sprint(GetBool(aMap, a.GetID()))
+		default:
+			if value, ok := aMap[a.GetID()]; ok {
+				config[a.GetID()] = valueString(value)
+			}
+		}
+	}
+	return config
+}
+
+// ValidateSpec walks the whole tree of arguments and commands looking for structural
+// mistakes which are not detected when the single arguments are inserted, e.g.:
+//  - a required positional inserted after an optional one
+//  - duplicate value names in the Vars of a StringFlag
+//  - a StringFlag naming some of its values in Vars, but not as many as its NArgs
+//  - commands requiring a subcommand without having any
+// An empty slice is returned if no issue is found
+func (p *ArgsParser) ValidateSpec() []error {
+	return validateSpec(p.argsList, "")
+}
+
+// CommandNames returns the names of all the commands of the program in alphabetical order
+func (p *ArgsParser) CommandNames() []string {
+	return commandNames(p.argsList)
+}
+
+// GetCommand returns the command registered with the given name or alias, and false if there
+// is no such command. Useful when the parser is built across several functions
+func (p *ArgsParser) GetCommand(name string) (*Command, bool) {
+	return findCommand(p.argsList, name)
+}
+
+// GetCommandMap returns the name and the argument map of the invoked command, as the
+// GetCommandMap function does, but only the registered commands are considered: other map
+// values (e.g. passed to ParseWithDefaults) are never mistaken for a command
+func (p *ArgsParser) GetCommandMap(aMap map[string]interface{}) (string, map[string]interface{}, error) {
+	return commandMap(p.argsList, aMap)
+}
+
+/************************************************************/
+func commandsOf(argsList []Argument) []*Command {
+	cmds := []*Command{}
+	for _, a := range argsList {
+		if a.getOrder() == orderCommand {
+			cmds = append(cmds, a.(*Command))
+		}
+	}
+	sort.Slice(cmds, func(i, j int) bool {
+		return cmds[i].GetID() < cmds[j].GetID()
+	})
+	return cmds
+}
+
+func commandNames(argsList []Argument) []string {
+	names := []string{}
+	for _, c := range commandsOf(argsList) {
+		names = append(names, c.GetID())
+	}
+	return names
+}
+
+var boolStrings = []string{"true", "false", "yes", "no", "on", "off", "1", "0"}
+
+// parseBoolString converts a human-friendly boolean string (case-insensitive)
+func parseBoolString(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("Error: invalid boolean value '%s'", s)
+}
+
+func validateSpec(argsList []Argument, cmdPath string) []error {
+	errs := []error{}
+	where := ""
+	if cmdPath != "" {
+		where = fmt.Sprintf(" in command '%s'", cmdPath)
+	}
+
+	// The positionals are checked in the order they were inserted, since sorting the
+	// arguments moves the required ones first
+	positionals := []PositionalArg{}
+	for _, a := range argsList {
+		if pos, ok := a.(PositionalArg); ok {
+			positionals = append(positionals, pos)
+		}
+	}
+	sort.SliceStable(positionals, func(i, j int) bool {
+		return positionals[i].inserted < positionals[j].inserted
+	})
+
+	optional := ""
+	for _, a := range positionals {
+		if !a.Required && optional == "" {
+			optional = a.GetID()
+		} else if a.Required && optional != "" {
+			errs = append(errs, fmt.Errorf("Error: required positional '%s' follows optional positional '%s'%s", a.GetID(), optional, where))
+		}
+	}
+
+	for _, a := range argsList {
+		switch a.getOrder() {
+		case orderStringFlag:
+			f := a.(StringFlag)
+			for i, v := range f.Vars[:f.named] {
+				if contains(f.Vars[:i], v) {
+					errs = append(errs, fmt.Errorf("Error: duplicate value name '%s' for flag '%s'%s", v, f.GetID(), where))
+				}
+			}
+			if f.named > 0 && f.named != f.NArgs {
+				errs = append(errs, fmt.Errorf("Error: flag '%s' takes %d values but names %d%s", f.GetID(), f.NArgs, f.named, where))
+			}
+		case orderCommand:
+			path := a.GetID()
+			if cmdPath != "" {
+				path = cmdPath + " " + path
+			}
+			if a.(*Command).subRequired && len(commandsOf(a.(*Command).argsList)) == 0 {
+				errs = append(errs, fmt.Errorf("Error: command '%s' requires a subcommand but has none", path))
+			}
+			errs = append(errs, validateSpec(a.(*Command).argsList, path)...)
+		}
+	}
+	return errs
+}
+
+// resolveAbbreviation looks for the only argument whose long flag or command name begins
+// with the given token, returning it with the matched representation. Returns nil if there's
+// no match. A BoolFlag matched both in its plain and in its negated form (e.g. "--nothing"
+// and "--no-nothing" for "--no") makes the token ambiguous too
+func resolveAbbreviation(token string, reprMap map[string]*Argument) (*Argument, string, error) {
+	prefix := token
+	if strings.HasPrefix(token, "--") {
+		prefix = token[2:]
+	} else if strings.HasPrefix(token, "-") {
+		return nil, "", nil
+	}
+	if prefix == "" {
+		return nil, "", nil
+	}
+
+	var match *Argument
+	matched := ""
+	ambiguous := false
+	candidates := []string{}
+	for r, a := range reprMap {
+		name := r
+		if strings.HasPrefix(r, "--") && prefix != token {
+			name = r[2:]
+		} else if strings.HasPrefix(r, "-") {
+			continue
+		}
+
+		if strings.HasPrefix(name, prefix) {
+			candidates = append(candidates, r)
+			ambiguous = ambiguous || (match != nil && (match != a || negates(*a, matched) != negates(*a, r)))
+			match, matched = a, r
+		}
+	}
+
+	if ambiguous {
+		sort.Strings(candidates)
+		return nil, "", fmt.Errorf("Error: ambiguous argument '%s' (could be: %s)", token, strings.Join(candidates, ", "))
+	}
+	return match, matched, nil
+}
+
+// maxSuggestionDistance is the maximum edit distance for a name to be suggested
+const maxSuggestionDistance = 2
+
+// closestName returns the name with the lowest edit distance from s, if not greater than
+// maxSuggestionDistance. Returns an empty string if there are no close names
+func closestName(s string, names []string) string {
+	best, bestDistance := "", maxSuggestionDistance+1
+	for _, name := range names {
+		if d := levenshtein(s, name); d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+	return best
+}
+
+// longFlags returns the long flag representations of the map in alphabetical order
+func longFlags(reprMap map[string]*Argument) []string {
+	flags := []string{}
+	for repr := range reprMap {
+		if strings.HasPrefix(repr, "--") {
+			flags = append(flags, repr)
+		}
+	}
+	sort.Strings(flags)
+	return flags
+}
+
+// levenshtein computes the edit distance between two strings
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j-1] + cost
+			if prev[j]+1 < curr[j] {
+				curr[j] = prev[j] + 1
+			}
+			if curr[j-1]+1 < curr[j] {
+				curr[j] = curr[j-1] + 1
+			}
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// expandShortFlags splits a token made of bunched short flags (e.g. "-vvv" or "-ab") into
+// the single flags. Only BoolFlags and CountFlags can be bunched, while the short of a
+// single-value StringFlag ends the token and takes the rest as its value (e.g. "-vofile" for
+// "-v -o file"): in that case value is true and the value is the last element. If nothing
+// follows the StringFlag, its values are the next tokens as usual. Nil is returned if the
+// token can't be split
+func expandShortFlags(token string, reprMap map[string]*Argument) (flags []string, value bool) {
+	if len(token) < 3 || token[0] != '-' || token[1] == '-' {
+		return nil, false
+	}
+
+	for i, c := range token[1:] {
+		f := "-" + string(c)
+		arg, ok := reprMap[f]
+		if !ok {
+			return nil, false
+		}
+
+		switch a := (*arg).(type) {
+		case BoolFlag, CountFlag:
+			flags = append(flags, f)
+		case StringFlag:
+			rest := token[i+1+len(string(c)):]
+			if rest == "" {
+				return append(flags, f), false
+			} else if a.NArgs != 1 || a.Variadic {
+				return nil, false
+			}
+			return append(flags, f, rest), true
+		default:
+			return nil, false
+		}
+	}
+	return flags, false
+}
+
+// consumeValues returns the nargs values following the flag at index i. The values stop at
+// the next flag or at "--", while negative numbers are always taken as values. In strict mode,
+// abbreviated and bunched flags stop the values too and a specific error is returned. The
+// errors name the missing value with its entry in vars, if any (see varName)
+func consumeValues(args []string, i, nargs int, reprMap map[string]*Argument, cfg *parseConfig, vars ...string) ([]string, error) {
+	n := len(args)
+	available := 0
+	for available < nargs && i+available+1 < n {
+		next := args[i+available+1]
+		if next == "--" {
+			break
 		}
-		for _, r := range b.Represent() {
-			if contains(a.Represent(), r) {
-				return fmt.Errorf("Error: representation '%s' already exists", r)
+		if isFlagToken(next, reprMap, cfg) {
+			if v := varName(vars, available); cfg.strictValues && v != "" {
+				return nil, fmt.Errorf("Error: value for '%s %s' is missing before flag '%s'", args[i], v, next)
+			} else if cfg.strictValues {
+				return nil, fmt.Errorf("Error: flag '%s' expected a value but found flag '%s'", args[i], next)
 			}
+			break
+		}
+		available++
+	}
+
+	if available < nargs {
+		usageErr := IncorrectUsageError{Flag: args[i], Expected: nargs, Available: available, Var: varName(vars, available)}
+		if i+available+1 < n {
+			usageErr.Next = args[i+available+1]
+		}
+		return nil, usageErr
+	}
+
+	values := make([]string, nargs)
+	copy(values, args[i+1:i+1+nargs])
+	return values, nil
+}
+
+// isFlagToken tells if a token can't be a flag value. Apart from negative numbers, these are
+// the flag representations or, in strict mode, the abbreviated and bunched flags
+func isFlagToken(token string, reprMap map[string]*Argument, cfg *parseConfig) bool {
+	if isNegativeNumber(token) {
+		return false
+	}
+	if _, found := reprMap[token]; found {
+		return true
+	}
+	if !cfg.strictValues {
+		return false
+	}
+
+	if cfg.abbreviations {
+		if arg, _, err := resolveAbbreviation(token, reprMap); err != nil || arg != nil {
+			return strings.HasPrefix(token, "-")
+		}
+	}
+	flags, _ := expandShortFlags(token, reprMap)
+	return flags != nil
+}
+
+// isNegativeNumber tells if a token is a negative number (e.g. "-5"), to be taken as a value
+// even if it looks like a flag
+func isNegativeNumber(s string) bool {
+	if !strings.HasPrefix(s, "-") {
+		return false
+	}
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// deprecationWarning returns the warning for a deprecated flag inserted as token (empty if
+// the flag is not deprecated)
+func deprecationWarning(a Argument, token string) string {
+	msg := deprecationMessage(a)
+	if msg == "" {
+		return ""
+	}
+	return fmt.Sprintf("Warning: '%s' is deprecated: %s", token, msg)
+}
+
+// deprecationMessage returns the Deprecated message of a flag (empty for the other arguments)
+func deprecationMessage(a Argument) string {
+	switch f := a.(type) {
+	case StringFlag:
+		return f.Deprecated
+	case ChoiceFlag:
+		return f.Deprecated
+	case IntFlag:
+		return f.Deprecated
+	case FloatFlag:
+		return f.Deprecated
+	case DurationFlag:
+		return f.Deprecated
+	case ListFlag:
+		return f.Deprecated
+	case BoolFlag:
+		return f.Deprecated
+	case CountFlag:
+		return f.Deprecated
+	}
+	return ""
+}
+
+// visibleArgs returns the arguments to be shown in the help messages, skipping the hidden flags
+func visibleArgs(argsList []Argument) []Argument {
+	visible := []Argument{}
+	for _, a := range argsList {
+		if !isHidden(a) {
+			visible = append(visible, a)
+		}
+	}
+	return visible
+}
+
+// flagExample returns the example values of a flag (empty if none)
+func flagExample(a Argument) string {
+	switch f := a.(type) {
+	case StringFlag:
+		return f.Example
+	case ChoiceFlag:
+		return f.Example
+	case IntFlag:
+		return f.Example
+	case FloatFlag:
+		return f.Example
+	case DurationFlag:
+		return f.Example
+	case ListFlag:
+		return f.Example
+	}
+	return ""
+}
+
+// flagGroup returns the help section of a flag (empty for the ungrouped ones)
+func flagGroup(a Argument) string {
+	switch f := a.(type) {
+	case StringFlag:
+		return f.Group
+	case ChoiceFlag:
+		return f.Group
+	case IntFlag:
+		return f.Group
+	case FloatFlag:
+		return f.Group
+	case DurationFlag:
+		return f.Group
+	case ListFlag:
+		return f.Group
+	case BoolFlag:
+		return f.Group
+	case CountFlag:
+		return f.Group
+	}
+	return ""
+}
+
+// isHidden tells if a flag is set not to be shown in the help messages
+func isHidden(a Argument) bool {
+	switch f := a.(type) {
+	case StringFlag:
+		return f.Hidden
+	case ChoiceFlag:
+		return f.Hidden
+	case IntFlag:
+		return f.Hidden
+	case FloatFlag:
+		return f.Hidden
+	case DurationFlag:
+		return f.Hidden
+	case ListFlag:
+		return f.Hidden
+	case BoolFlag:
+		return f.Hidden
+	case CountFlag:
+		return f.Hidden
+	}
+	return false
+}
+
+// applyDefaults fills the absent flags having a default value
+func applyDefaults(argsMap map[string]interface{}, argsList []Argument) {
+	for _, a := range argsList {
+		if IsPresent(argsMap, a.GetID()) {
+			continue
+		}
+
+		switch f := a.(type) {
+		case IntFlag:
+			if f.Default != nil {
+				argsMap[f.GetID()] = append([]int{}, f.Default...)
+			}
+		case FloatFlag:
+			if f.Default != nil {
+				argsMap[f.GetID()] = append([]float64{}, f.Default...)
+			}
+		case DurationFlag:
+			if f.Default != nil {
+				argsMap[f.GetID()] = append([]time.Duration{}, f.Default...)
+			}
+		case BoolFlag:
+			if f.Default {
+				argsMap[f.GetID()] = true
+			}
+		}
+	}
+}
+
+// negates tells if the representation is the negated form of a Negatable BoolFlag
+func negates(a Argument, repr string) bool {
+	f, ok := a.(BoolFlag)
+	return ok && isNegation(f, repr)
+}
+
+// isNegation tells if the representation matched by the token inserting a Negatable BoolFlag
+// is its "--no-" form (also with a single dash, see SetSingleDashLong)
+func isNegation(f BoolFlag, repr string) bool {
+	return f.Negatable && strings.TrimLeft(repr, "-") == "no-"+f.Name
+}
+
+// applyEnv fills the absent flags with the values of the corresponding environment variables:
+// the one named by the Env field of the flag, otherwise the one built from the prefix (if set)
+func applyEnv(argsMap map[string]interface{}, argsList []Argument, cfg *parseConfig) error {
+	for _, a := range argsList {
+		if IsPresent(argsMap, a.GetID()) {
+			continue
+		}
+
+		name := flagEnv(a)
+		if name == "" && cfg.envPrefix != "" {
+			name = envName(cfg.envPrefix, cfg.cmdPath, a.GetID())
+		}
+		value := os.Getenv(name)
+		if name == "" || value == "" {
+			continue
+		}
+
+		switch a.getOrder() {
+		case orderStringFlag:
+			values := strings.Fields(value)
+			if len(values) != a.(StringFlag).NArgs && !a.(StringFlag).Variadic {
+				return fmt.Errorf("Error: expected %d values in environment variable '%s', got %d", a.(StringFlag).NArgs, name, len(values))
+			}
+			if err := cfg.cancelled(); err != nil {
+				return err
+			}
+			values = a.(StringFlag).transform(values)
+			if err := a.(StringFlag).validate(cfg.context(), values, argName(a)); err != nil {
+				return err
+			}
+			argsMap[a.GetID()] = values
+		case orderIntFlag:
+			values := strings.Fields(value)
+			if len(values) != a.(IntFlag).NArgs {
+				return fmt.Errorf("Error: expected %d values in environment variable '%s', got %d", a.(IntFlag).NArgs, name, len(values))
+			}
+			ints := make([]int, len(values))
+			for j, v := range values {
+				var err error
+				if ints[j], err = strconv.Atoi(v); err != nil {
+					return fmt.Errorf("Error: value '%s' in environment variable '%s' is not an integer", v, name)
+				}
+			}
+			argsMap[a.GetID()] = ints
+		case orderListFlag:
+			argsMap[a.GetID()] = strings.Fields(value)
+		case orderBoolFlag:
+			b, err := parseBoolString(value)
+			if err != nil {
+				return fmt.Errorf("Error: invalid value '%s' in environment variable '%s' (accepted: %s)", value, name, strings.Join(boolStrings, ", "))
+			}
+			argsMap[a.GetID()] = b
 		}
 	}
 	return nil
 }
+
+// flagEnv returns the environment variable explicitly bound to a flag (empty if none)
+func flagEnv(a Argument) string {
+	switch f := a.(type) {
+	case StringFlag:
+		return f.Env
+	case IntFlag:
+		return f.Env
+	}
+	return ""
+}
+
+// envName builds the name of the environment variable bound to a flag
+func envName(prefix string, cmdPath []string, id string) string {
+	parts := append(append([]string{prefix}, cmdPath...), id)
+	name := strings.ToUpper(strings.Join(parts, "_"))
+	return strings.Replace(name, "-", "_", -1)
+}
+
+// isRequiredFlag tells if a flag must be inserted by the user
+func isRequiredFlag(a Argument) bool {
+	switch f := a.(type) {
+	case StringFlag:
+		return f.Required
+	case ListFlag:
+		return f.Required
+	case BoolFlag:
+		return f.Required
+	}
+	return false
+}
+
+// valueString renders a value of the parsed map as a string
+func valueString(value interface{}) string {
+	switch list := value.(type) {
+	case []string:
+		return strings.Join(list, ",")
+	case []int:
+		values := make([]string, len(list))
+		for i, v := range list {
+			values[i] = strconv.Itoa(v)
+		}
+		return strings.Join(values, ",")
+	case []float64:
+		values := make([]string, len(list))
+		for i, v := range list {
+			values[i] = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		return strings.Join(values, ",")
+	case []time.Duration:
+		values := make([]string, len(list))
+		for i, v := range list {
+			values[i] = v.String()
+		}
+		return strings.Join(values, ",")
+	}
+	return fmt.Sprint(value)
+}
+
+func contains(arr []string, val string) bool {
+	for _, v := range arr {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+// requirement tells that the argument identified by flag can't be inserted without dep
+type requirement struct {
+	flag, dep string
+}
+
+// newRequirements checks that the flag and its dependencies exist in the list of arguments
+func newRequirements(argsList []Argument, flag string, deps []string) ([]requirement, error) {
+	reqs := []requirement{}
+	for _, id := range append([]string{flag}, deps...) {
+		if findArg(argsList, id) == nil {
+			return nil, fmt.Errorf("Error: identifier '%s' not found", id)
+		}
+		if id != flag {
+			reqs = append(reqs, requirement{flag, id})
+		}
+	}
+	return reqs, nil
+}
+
+// checkRequired returns an error for the first required key absent from the map
+func checkRequired(argsMap map[string]interface{}, keys []string) error {
+	for _, key := range keys {
+		if !IsPresent(argsMap, key) {
+			return MissingArgumentError{Key: key}
+		}
+	}
+	return nil
+}
+
+// checkRequires returns an error for the first unsatisfied dependency between flags
+func checkRequires(argsMap map[string]interface{}, argsList []Argument, requires []requirement) error {
+	for _, r := range requires {
+		if IsPresent(argsMap, r.flag) && !IsPresent(argsMap, r.dep) {
+			return fmt.Errorf("Error: flag '%s' requires '%s'", argName(findArg(argsList, r.flag)), argName(findArg(argsList, r.dep)))
+		}
+	}
+	return nil
+}
+
+// findCommand returns the command of the list with the given name or alias
+func findCommand(argsList []Argument, name string) (*Command, bool) {
+	for _, c := range commandsOf(argsList) {
+		if contains(c.Represent(), name) {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// findArg returns the argument with the given identifier (nil if not found)
+func findArg(argsList []Argument, id string) Argument {
+	for _, a := range argsList {
+		if a.GetID() == id {
+			return a
+		}
+	}
+	return nil
+}
+
+// argName returns the name of an argument as the user would type it (e.g. "--cert")
+func argName(a Argument) string {
+	reprs := a.Represent()
+	if len(reprs) == 0 {
+		return a.GetID()
+	}
+	return reprs[len(reprs)-1]
+}
+
+// checkVariadic makes sure that a variadic positional is the last positional argument
+func checkVariadic(argsList []Argument, a PositionalArg) error {
+	if a.Variadic && a.Kind != KindString {
+		return fmt.Errorf("Error: variadic positional '%s' must be of KindString", a.Name)
+	}
+
+	for _, b := range argsList {
+		pos, ok := b.(PositionalArg)
+		if !ok {
+			continue
+		}
+		if pos.Variadic {
+			return fmt.Errorf("Error: positional '%s' cannot follow variadic positional '%s'", a.Name, pos.Name)
+		}
+		if a.Variadic && a.Required && !pos.Required {
+			return fmt.Errorf("Error: required variadic positional '%s' cannot follow optional positional '%s'", a.Name, pos.Name)
+		}
+	}
+	return nil
+}
+
+// setHelpFlagReps replaces the help flag of the list with one having the given representations
+func setHelpFlagReps(argsList *[]Argument, idx *argsIndex, short, long string) error {
+	for i, a := range *argsList {
+		if f, ok := a.(HelpFlag); ok {
+			f.Short, f.Long = short, long
+			return replaceArg(argsList, idx, i, f)
+		}
+	}
+	return fmt.Errorf("Error: the help flag is disabled")
+}
+
+// replaceArg replaces the i-th argument of the list, checking the identifiers of the new one
+// against the other arguments
+func replaceArg(argsList *[]Argument, idx *argsIndex, i int, b Argument) error {
+	others := append(append([]Argument{}, (*argsList)[:i]...), (*argsList)[i+1:]...)
+	if err := (&argsIndex{}).insert(&others, b); err != nil {
+		return err
+	}
+	*argsList = others
+	idx.reset()
+	return nil
+}
+
+// argsIndex keeps the identifiers and the representations taken in a list of arguments, so
+// that a new argument is checked without scanning the whole list. The sets are built at the
+// first insertion and after a removal (see reset), while the mutex makes the insertions in
+// the same list goroutine-safe. Only the insertions are synchronized: the other changes to
+// the list (sorting, removals, etc.) must not happen at the same time
+type argsIndex struct {
+	mu          sync.Mutex
+	ids         map[string]struct{}
+	reprs       map[string]struct{}
+	positionals uint64
+}
+
+// next numbers a new positional of the list, keeping track of the insertion order after the
+// list is sorted (see ValidateSpec)
+func (idx *argsIndex) next() uint64 {
+	if idx == nil {
+		return 0
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.positionals++
+	return idx.positionals
+}
+
+// clone returns an empty index for a copy of the list, which keeps numbering the positionals
+// after the ones already inserted
+func (idx *argsIndex) clone() *argsIndex {
+	if idx == nil {
+		return &argsIndex{}
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return &argsIndex{positionals: idx.positionals}
+}
+
+// insert checks the identifiers of the argument, runs the additional checks and then appends
+// the argument to the list
+func (idx *argsIndex) insert(argsList *[]Argument, b Argument, checks ...func([]Argument) error) error {
+	if idx == nil {
+		// Parsers and commands not built by their constructors have no index
+		idx = &argsIndex{}
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.ids == nil {
+		idx.ids = make(map[string]struct{})
+		idx.reprs = make(map[string]struct{})
+		for _, a := range *argsList {
+			idx.add(a)
+		}
+	}
+	if err := idx.checkIdentifiers(b); err != nil {
+		return err
+	}
+	for _, check := range checks {
+		if err := check(*argsList); err != nil {
+			return err
+		}
+	}
+
+	*argsList = append(*argsList, b)
+	idx.add(b)
+	return nil
+}
+
+// checkIdentifiers tells if the identifier or a representation of the argument is taken
+func (idx *argsIndex) checkIdentifiers(b Argument) error {
+	if _, ok := idx.ids[b.GetID()]; ok {
+		return fmt.Errorf("Error: identifier '%s' already exists", b.GetID())
+	}
+	for _, r := range b.Represent() {
+		if _, ok := idx.reprs[r]; ok {
+			return fmt.Errorf("Error: representation '%s' already exists", r)
+		}
+	}
+	return nil
+}
+
+// add marks the identifier and the representations of the argument as taken
+func (idx *argsIndex) add(a Argument) {
+	idx.ids[a.GetID()] = struct{}{}
+	for _, r := range a.Represent() {
+		idx.reprs[r] = struct{}{}
+	}
+}
+
+// reset drops the sets after an argument is removed from the list
+func (idx *argsIndex) reset() {
+	if idx == nil {
+		return
+	}
+	idx.mu.Lock()
+	idx.ids, idx.reprs = nil, nil
+	idx.mu.Unlock()
+}
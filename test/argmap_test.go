@@ -1,9 +1,19 @@
 package test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/zorzr/argmap"
 )
@@ -89,6 +99,29 @@ func TestCorrectStringFlagFull_ExtraValue(t *testing.T) {
 	}
 }
 
+func TestWrongStringFlag_ValuesBeforeFlag(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "coords", NArgs: 2})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose"})
+
+	os.Args = []string{ProjectName, "--coords", "3", "--verbose", "4"}
+	_, err := parser.Parse()
+	if err == nil {
+		t.Errorf("Expecting error, got nil")
+	} else if exp := "Error: --coords needs 2 values but only 1 available before '--verbose'"; err.Error() != exp {
+		t.Errorf("Wrong error message: expected %s, got %s", exp, err)
+	}
+
+	parser.NewStringFlag(argmap.StringFlag{Name: "name"})
+	_, err = parser.ParseFrom([]string{"--name", "--verbose"})
+	if exp := "Error: --name needs 1 value but none is available before '--verbose'"; err == nil || err.Error() != exp {
+		t.Errorf("Wrong error message: expected %s, got %v", exp, err)
+	}
+}
+
 /**********************************************************************/
 /*** STRINGFLAG INSERTION WITH LESS PARAMETERS ************************/
 /**********************************************************************/
@@ -147,6 +180,267 @@ func TestWrongStringFlag_UnspecifiedNArgs(t *testing.T) {
 	}
 }
 
+func TestCorrectStringFlag_CommaSplit(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "coords", NArgs: 2, CommaSplit: true})
+
+	expMap := map[string]interface{}{"coords": []string{"3", "4"}}
+	os.Args = []string{ProjectName, "--coords", "3,4"}
+	aMap, err := parser.Parse()
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
+
+	os.Args = []string{ProjectName, "--coords", "3", "4"}
+	aMap, err = parser.Parse()
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
+
+	os.Args = []string{ProjectName, "--coords", "3,4,5"}
+	_, err = parser.Parse()
+	if err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+}
+
+func TestCorrectStringFlag_Transform(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "level", NArgs: 2, Transform: strings.ToLower})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "count", Kind: argmap.KindInt, Transform: strings.TrimSpace})
+
+	os.Args = []string{ProjectName, "--level", "DEBUG", "Info", " 42 "}
+	aMap, err := parser.Parse()
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"level": []string{"debug", "info"}, "count": 42}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %v, got %v", expMap, aMap)
+	}
+}
+
+func TestRequiredStringFlag(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "name", Short: "n", Help: "your name", Required: true})
+	parser.NewListFlag(argmap.ListFlag{Short: "l", Required: true})
+
+	if help := parser.GenerateHelp(); !strings.Contains(help, "your name (required)") {
+		t.Errorf("Missing required mark in help: got %s", help)
+	}
+
+	os.Args = []string{ProjectName, "-l", "a"}
+	_, err := parser.Parse()
+	if err == nil {
+		t.Errorf("Expecting error, got nil")
+	} else if exp := "Error: missing required flag '--name'"; err.Error() != exp {
+		t.Errorf("Wrong error message: expected %s, got %s", exp, err)
+	}
+
+	os.Args = []string{ProjectName, "-l", "a", "-n", "jack"}
+	if _, err = parser.Parse(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCorrectStringFlag_NegativeNumber(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "offset", NArgs: 2})
+	parser.NewIntFlag(argmap.IntFlag{Name: "n"})
+	parser.NewBoolFlag(argmap.BoolFlag{Short: "5"})
+
+	aMap, err := parser.ParseFrom([]string{"--offset", "-5", "-0.5", "--n", "-3"})
+	expMap := map[string]interface{}{"offset": []string{"-5", "-0.5"}, "n": []int{-3}}
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %v, got %v", expMap, aMap)
+	}
+}
+
+func TestCorrectStringFlag_Validate(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "ports", Short: "p", NArgs: 2, Env: "TEST_ARGMAP_PORTS", Validate: func(s string) error {
+		if port, err := strconv.Atoi(s); err != nil || port < 1 || port > 65535 {
+			return errors.New("not a valid port")
+		}
+		return nil
+	}})
+	defer os.Unsetenv("TEST_ARGMAP_PORTS")
+
+	if _, err := parser.ParseFrom([]string{"--ports", "80", "443"}); err != nil {
+		t.Error(err)
+	}
+
+	tests := [][]string{{"--ports", "80", "70000"}, {"-p", "http", "443"}, {}}
+	exps := []string{"--ports", "-p", "--ports"}
+	os.Setenv("TEST_ARGMAP_PORTS", "80 0")
+	for i, args := range tests {
+		_, err := parser.ParseFrom(args)
+		if exp := "Error: invalid value for '" + exps[i] + "': not a valid port"; err == nil || err.Error() != exp {
+			t.Errorf("Wrong error for %v: expected %s, got %v", args, exp, err)
+		}
+	}
+}
+
+func TestStrictFlagValues(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "hello"})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "spanish", Short: "s"})
+	parser.NewBoolFlag(argmap.BoolFlag{Short: "q"})
+	parser.SetAbbreviations(true)
+
+	if aMap, err := parser.ParseFrom([]string{"--hello", "-sq"}); err != nil || !reflect.DeepEqual(aMap["hello"], []string{"-sq"}) {
+		t.Errorf("Wrong lenient parsing: got %v, %v", aMap, err)
+	}
+
+	parser.SetStrictFlagValues(true)
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"--hello", "--spanish"}, "Error: flag '--hello' expected a value but found flag '--spanish'"},
+		{[]string{"--hello", "--spa"}, "Error: flag '--hello' expected a value but found flag '--spa'"},
+		{[]string{"--hello", "-sq"}, "Error: flag '--hello' expected a value but found flag '-sq'"},
+		{[]string{"--hello", "-5"}, ""},
+	}
+	for _, test := range tests {
+		_, err := parser.ParseFrom(test.args)
+		if test.err == "" && err != nil {
+			t.Error(err)
+		} else if test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("Wrong error for %v: expected %s, got %v", test.args, test.err, err)
+		}
+	}
+}
+
+func TestStringFlagAllowDashValue(t *testing.T) {
+	parser := argmap.NewArgsParser("grep", "")
+	parser.NewStringFlag(argmap.StringFlag{Name: "pattern", AllowDashValue: true})
+	parser.NewStringFlag(argmap.StringFlag{Name: "output"})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose", Short: "v"})
+	parser.SetStrictFlagValues(true)
+
+	aMap, err := parser.ParseFrom([]string{"--pattern", "-v", "--verbose"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if values, _ := argmap.GetList(aMap, "pattern"); !reflect.DeepEqual(values, []string{"-v"}) {
+		t.Errorf("Expected ['-v'], got %v", values)
+	}
+	if !argmap.GetBool(aMap, "verbose") {
+		t.Errorf("Expected --verbose to be parsed after the pattern")
+	}
+
+	if _, err := parser.ParseFrom([]string{"--output", "-v"}); err == nil {
+		t.Errorf("Expected the other flags to stay strict")
+	}
+
+	_, err = parser.ParseFrom([]string{"--pattern"})
+	var usageErr argmap.IncorrectUsageError
+	if !errors.As(err, &usageErr) || usageErr.Flag != "--pattern" {
+		t.Errorf("Expected an IncorrectUsageError for '--pattern', got %v", err)
+	}
+}
+
+func TestStringFlagVariadic(t *testing.T) {
+	parser := argmap.NewArgsParser("copy", "")
+	parser.NewStringFlag(argmap.StringFlag{Name: "files", Short: "f", Variadic: true, Vars: []string{"file"}})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose", Short: "v"})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "dest"})
+	if files := (argmap.StringFlag{Name: "files", Variadic: true}); files.Arity() != -1 {
+		t.Errorf("Expected a variable arity, got %d", files.Arity())
+	}
+
+	cases := []struct {
+		args  []string
+		files []string
+		dest  string
+	}{
+		{[]string{"--files", "a", "b", "c", "-v"}, []string{"a", "b", "c"}, ""},
+		{[]string{"--files", "-v"}, []string{}, ""},
+		{[]string{"--files"}, []string{}, ""},
+		{[]string{"out", "-f", "a", "-5"}, []string{"a", "-5"}, "out"},
+		{[]string{"-f", "a", "b", "--", "out"}, []string{"a", "b"}, "out"},
+	}
+	for _, c := range cases {
+		aMap, err := parser.ParseFrom(c.args)
+		if err != nil {
+			t.Errorf("Unexpected error for %v: %s", c.args, err)
+			continue
+		}
+		if files, _ := argmap.GetList(aMap, "files"); !reflect.DeepEqual(files, c.files) {
+			t.Errorf("Expected files %v for %v, got %v", c.files, c.args, files)
+		}
+		if dest, _ := argmap.GetPositional(aMap, "dest"); dest != c.dest {
+			t.Errorf("Expected dest '%s' for %v, got '%s'", c.dest, c.args, dest)
+		}
+	}
+
+	if help := parser.GenerateHelp(); !strings.Contains(help, "-f, --files file ... ") {
+		t.Errorf("Expected the variadic metavar in the help:\n%s", help)
+	}
+	if err := parser.NewStringFlag(argmap.StringFlag{Name: "bad", NArgs: 2, Variadic: true}); err == nil {
+		t.Errorf("Expected an error for a variadic flag with NArgs")
+	}
+}
+
+func TestStringFlagVarsInErrors(t *testing.T) {
+	positive := func(s string) error {
+		if n, err := strconv.Atoi(s); err != nil || n <= 0 {
+			return errors.New("not a positive integer")
+		}
+		return nil
+	}
+	parser := argmap.NewArgsParser("resize", "")
+	parser.NewStringFlag(argmap.StringFlag{Name: "size", NArgs: 2, Vars: []string{"width", "height"}, Validate: positive})
+	parser.NewStringFlag(argmap.StringFlag{Name: "scale", Validate: positive})
+
+	parser.NewStringFlag(argmap.StringFlag{Name: "coords", NArgs: 2, Vars: []string{"x", "y"}, CommaSplit: true})
+	parser.NewBoolFlag(argmap.BoolFlag{Short: "v"})
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--size", "800", "-1"}, "Error: value for '--size height' is invalid: not a positive integer"},
+		{[]string{"--size", "x", "600"}, "Error: value for '--size width' is invalid: not a positive integer"},
+		{[]string{"--scale", "0"}, "Error: invalid value for '--scale': not a positive integer"},
+		{[]string{"--size", "800"}, "Error: value for '--size height' is missing"},
+		{[]string{"--size", "800", "-v"}, "Error: value for '--size height' is missing before '-v'"},
+		{[]string{"--coords", "3"}, "Error: value for '--coords y' is missing"},
+		{[]string{"--coords", "3,4,5"}, "Error: expected 2 comma-separated values for flag '--coords', got 3"},
+	}
+	for _, test := range tests {
+		_, err := parser.ParseFrom(test.args)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Wrong error for %v: expected %s, got %v", test.args, test.expected, err)
+		}
+	}
+
+	// The missing value is named in the IncorrectUsageError and in strict mode too
+	var usageErr argmap.IncorrectUsageError
+	if _, err := parser.ParseFrom([]string{"--size"}); !errors.As(err, &usageErr) || usageErr.Var != "width" {
+		t.Errorf("Expected the width to be missing, got %v", err)
+	}
+	parser.SetStrictFlagValues(true)
+	_, err := parser.ParseFrom([]string{"--size", "800", "-v"})
+	if err == nil || err.Error() != "Error: value for '--size height' is missing before flag '-v'" {
+		t.Errorf("Expected an error naming the height, got %v", err)
+	}
+}
+
 /**********************************************************************/
 /*** LISTFLAG INSERTION AND PARSING ***********************************/
 /**********************************************************************/
@@ -252,152 +546,465 @@ func TestWrongListFlag(t *testing.T) {
 	}
 }
 
+func TestListFlagHelpFormat(t *testing.T) {
+	flag := argmap.ListFlag{Name: "list", Var: "item"}
+	if left := flag.GetHelpStrings()[0]; left != "--list item item... " {
+		t.Errorf("Wrong default help: got '%s'", left)
+	}
+
+	flag.HelpFormat = argmap.ListHelpOptional
+	if left := flag.GetHelpStrings()[0]; left != "--list item [item ...] " {
+		t.Errorf("Wrong help: got '%s'", left)
+	}
+
+	flag.HelpFormat = argmap.ListHelpEllipsis
+	if left := flag.GetHelpStrings()[0]; left != "--list item... " {
+		t.Errorf("Wrong help: got '%s'", left)
+	}
+}
+
+func TestCorrectListFlag_Max(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewListFlag(argmap.ListFlag{Name: "items", Max: 3})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "final"})
+
+	aMap, err := parser.ParseFrom([]string{"--items", "a", "b", "c", "finalpos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := argmap.GetList(aMap, "items"); !reflect.DeepEqual(list, []string{"a", "b", "c"}) {
+		t.Errorf("Wrong list: got %v", list)
+	}
+	if final, _ := argmap.GetPositional(aMap, "final"); final != "finalpos" {
+		t.Errorf("Wrong positional: expected finalpos, got %s", final)
+	}
+
+	aMap, err = parser.ParseFrom([]string{"--items", "a"})
+	if list, _ := argmap.GetList(aMap, "items"); err != nil || !reflect.DeepEqual(list, []string{"a"}) {
+		t.Errorf("Wrong list: got %v, %v", list, err)
+	}
+}
+
 /**********************************************************************/
-/*** BOOLFLAG INSERTION AND PARSING ***********************************/
+/*** TYPED FLAGS INSERTION AND PARSING ********************************/
 /**********************************************************************/
-func TestCorrectBoolFlag_JustName(t *testing.T) {
-	oldArgs := os.Args
-	defer func() { os.Args = oldArgs }()
-
+func TestCorrectIntFlag(t *testing.T) {
 	parser := argmap.NewArgsParser(ProjectName, t.Name())
-	err := parser.NewBoolFlag(argmap.BoolFlag{Name: "hello"})
+	parser.NewIntFlag(argmap.IntFlag{Name: "count", Short: "c", NArgs: 2})
+	parser.NewIntFlag(argmap.IntFlag{Name: "retries", Default: []int{3}})
+
+	aMap, err := parser.ParseFrom([]string{"--count", "1", "-2"})
 	if err != nil {
 		t.Error(err)
+	} else if expMap := map[string]interface{}{"count": []int{1, -2}, "retries": []int{3}}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %v, got %v", expMap, aMap)
+	} else if v, err := argmap.GetInt(aMap, "count", 1); err != nil || v != -2 {
+		t.Errorf("Wrong value: got %d, %v", v, err)
 	}
 
-	os.Args = []string{ProjectName, "--hello"}
-	aMap, err := parser.Parse()
+	aMap, err = parser.ParseFrom([]string{"-c", "1", "2", "--retries", "5"})
 	if err != nil {
 		t.Error(err)
-	} else if expMap := map[string]interface{}{"hello": true}; !reflect.DeepEqual(aMap, expMap) {
-		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	} else if values, _ := argmap.GetIntArray(aMap, "retries"); !reflect.DeepEqual(values, []int{5}) {
+		t.Errorf("Wrong values: got %v", values)
 	}
 }
 
-func TestCorrectBoolFlag_JustShort(t *testing.T) {
-	oldArgs := os.Args
-	defer func() { os.Args = oldArgs }()
+func TestWrongIntFlag(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewIntFlag(argmap.IntFlag{Name: "count"})
+
+	_, err := parser.ParseFrom([]string{"--count", "abc"})
+	if err == nil {
+		t.Errorf("Expecting error, got nil")
+	} else if exp := "Error: value 'abc' for flag '--count' is not an integer"; err.Error() != exp {
+		t.Errorf("Wrong error message: expected %s, got %s", exp, err)
+	}
+
+	if err = parser.NewIntFlag(argmap.IntFlag{Name: "size", NArgs: 2, Default: []int{1}}); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+}
 
+func TestCorrectFloatFlag(t *testing.T) {
 	parser := argmap.NewArgsParser(ProjectName, t.Name())
-	err := parser.NewBoolFlag(argmap.BoolFlag{Short: "hi"})
+	parser.NewFloatFlag(argmap.FloatFlag{Short: "o", NArgs: 2})
+	parser.NewFloatFlag(argmap.FloatFlag{Name: "ratio", Default: []float64{0.5}})
+
+	aMap, err := parser.ParseFrom([]string{"-o", "1.5", "2.5"})
 	if err != nil {
 		t.Error(err)
+	} else if expMap := map[string]interface{}{"o": []float64{1.5, 2.5}, "ratio": []float64{0.5}}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %v, got %v", expMap, aMap)
+	} else if v, err := argmap.GetFloat(aMap, "o", 1); err != nil || v != 2.5 {
+		t.Errorf("Wrong value: got %f, %v", v, err)
 	}
 
-	os.Args = []string{ProjectName, "-hi"}
-	aMap, err := parser.Parse()
-	if err != nil {
-		t.Error(err)
-	} else if expMap := map[string]interface{}{"hi": true}; !reflect.DeepEqual(aMap, expMap) {
-		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	_, err = parser.ParseFrom([]string{"-o", "1.5", "two"})
+	if err == nil {
+		t.Errorf("Expecting error, got nil")
+	} else if exp := "Error: value 'two' for flag '-o' is not a number"; err.Error() != exp {
+		t.Errorf("Wrong error message: expected %s, got %s", exp, err)
 	}
 }
 
-func TestCorrectBoolFlag_Full(t *testing.T) {
-	oldArgs := os.Args
-	defer func() { os.Args = oldArgs }()
-
+func TestCorrectChoiceFlag(t *testing.T) {
 	parser := argmap.NewArgsParser(ProjectName, t.Name())
-	err := parser.NewBoolFlag(argmap.BoolFlag{Name: "hello", Short: "hi", Help: "greets you"})
+	parser.NewChoiceFlag(argmap.ChoiceFlag{Name: "log-level", Choices: []string{"debug", "info", "warn"}})
+
+	aMap, err := parser.ParseFrom([]string{"--log-level", "info"})
 	if err != nil {
 		t.Error(err)
+	} else if value, err := argmap.GetListValue(aMap, "log-level", 0); err != nil || value != "info" {
+		t.Errorf("Wrong value: got %s, %v", value, err)
 	}
 
-	os.Args = []string{ProjectName, "--hello"}
-	aMap, err := parser.Parse()
+	_, err = parser.ParseFrom([]string{"--log-level", "foo"})
+	if err == nil {
+		t.Errorf("Expecting error, got nil")
+	} else if exp := "Error: invalid value 'foo' for '--log-level' (allowed: debug, info, warn)"; err.Error() != exp {
+		t.Errorf("Wrong error message: expected %s, got %s", exp, err)
+	}
+
+	if help := parser.GenerateHelp(); !strings.Contains(help, "--log-level {debug,info,warn}") {
+		t.Errorf("Missing choices in help: got %s", help)
+	}
+	if err = parser.NewChoiceFlag(argmap.ChoiceFlag{Name: "empty"}); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+}
+
+func TestCorrectDurationFlag(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewDurationFlag(argmap.DurationFlag{Name: "timeout", Short: "t"})
+	parser.NewDurationFlag(argmap.DurationFlag{Name: "interval", Default: []time.Duration{time.Minute}})
+
+	aMap, err := parser.ParseFrom([]string{"--timeout", "30s"})
 	if err != nil {
-		t.Error(err)
-	} else if expMap := map[string]interface{}{"hello": true}; !reflect.DeepEqual(aMap, expMap) {
-		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+		t.Fatal(err)
+	}
+	if d, err := argmap.GetDuration(aMap, "timeout", 0); err != nil || d != 30*time.Second {
+		t.Errorf("Wrong duration: got %v, %v", d, err)
+	}
+	if d, err := argmap.GetDurationArray(aMap, "interval"); err != nil || !reflect.DeepEqual(d, []time.Duration{time.Minute}) {
+		t.Errorf("Wrong default durations: got %v, %v", d, err)
+	}
+
+	_, err = parser.ParseFrom([]string{"--timeout", "30x"})
+	if exp := "Error: value '30x' for '--timeout' is not a valid duration"; err == nil || err.Error() != exp {
+		t.Errorf("Wrong error: expected %s, got %v", exp, err)
 	}
 }
 
 /**********************************************************************/
-/*** POSITIONAL ARGUMENTS *********************************************/
+/*** BOOLFLAG INSERTION AND PARSING ***********************************/
 /**********************************************************************/
-func TestCorrectPositional_Required(t *testing.T) {
+func TestCorrectBoolFlag_JustName(t *testing.T) {
 	oldArgs := os.Args
 	defer func() { os.Args = oldArgs }()
 
 	parser := argmap.NewArgsParser(ProjectName, t.Name())
-	err := parser.NewPositionalArg(argmap.PositionalArg{Name: "your_name", Required: true})
+	err := parser.NewBoolFlag(argmap.BoolFlag{Name: "hello"})
 	if err != nil {
 		t.Error(err)
-		return
 	}
 
-	os.Args = []string{ProjectName, "mario"}
+	os.Args = []string{ProjectName, "--hello"}
 	aMap, err := parser.Parse()
 	if err != nil {
 		t.Error(err)
-	} else if expMap := map[string]interface{}{"your_name": "mario"}; !reflect.DeepEqual(aMap, expMap) {
+	} else if expMap := map[string]interface{}{"hello": true}; !reflect.DeepEqual(aMap, expMap) {
 		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
 	}
 }
 
-func TestWrongPositional_Required(t *testing.T) {
+func TestCorrectBoolFlag_JustShort(t *testing.T) {
 	oldArgs := os.Args
 	defer func() { os.Args = oldArgs }()
 
 	parser := argmap.NewArgsParser(ProjectName, t.Name())
-	err := parser.NewPositionalArg(argmap.PositionalArg{Name: "your_name", Required: true})
+	err := parser.NewBoolFlag(argmap.BoolFlag{Short: "hi"})
 	if err != nil {
 		t.Error(err)
-		return
 	}
 
-	os.Args = []string{ProjectName}
-	_, err = parser.Parse()
-	if err == nil || err.Error()[:len(ERRORMissingPositional)] != ERRORMissingPositional {
-		t.Errorf("Expecting error, got nil or wrong one")
+	os.Args = []string{ProjectName, "-hi"}
+	aMap, err := parser.Parse()
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"hi": true}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
 	}
 }
 
-func TestCorrectPositional_Optional(t *testing.T) {
+func TestCorrectBoolFlag_Full(t *testing.T) {
 	oldArgs := os.Args
 	defer func() { os.Args = oldArgs }()
 
 	parser := argmap.NewArgsParser(ProjectName, t.Name())
-	parser.NewPositionalArg(argmap.PositionalArg{Name: "your_name", Required: true})
-	err := parser.NewPositionalArg(argmap.PositionalArg{Name: "greet_lang"})
+	err := parser.NewBoolFlag(argmap.BoolFlag{Name: "hello", Short: "hi", Help: "greets you"})
 	if err != nil {
 		t.Error(err)
-		return
 	}
 
-	os.Args = []string{ProjectName, "mario"}
+	os.Args = []string{ProjectName, "--hello"}
 	aMap, err := parser.Parse()
 	if err != nil {
 		t.Error(err)
-	} else if expMap := map[string]interface{}{"your_name": "mario"}; !reflect.DeepEqual(aMap, expMap) {
-		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
-	}
-
-	os.Args = []string{ProjectName, "mario", "spanish"}
-	aMap, err = parser.Parse()
-	if err != nil {
-		t.Error(err)
-	} else if expMap := map[string]interface{}{"your_name": "mario", "greet_lang": "spanish"}; !reflect.DeepEqual(aMap, expMap) {
+	} else if expMap := map[string]interface{}{"hello": true}; !reflect.DeepEqual(aMap, expMap) {
 		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
 	}
 }
 
-func TestCorrectPositional_TwoRequiredOneOptional(t *testing.T) {
+func TestCorrectBoolFlag_ExplicitValue(t *testing.T) {
 	oldArgs := os.Args
 	defer func() { os.Args = oldArgs }()
 
 	parser := argmap.NewArgsParser(ProjectName, t.Name())
-	parser.NewPositionalArg(argmap.PositionalArg{Name: "greet_lang", Required: true})
-	parser.NewPositionalArg(argmap.PositionalArg{Name: "your_surname"})
-	parser.NewPositionalArg(argmap.PositionalArg{Name: "your_name", Required: true})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "color", Short: "c"})
 
-	os.Args = []string{ProjectName, "en", "mario"}
-	aMap, err := parser.Parse()
-	if err != nil {
-		t.Error(err)
-	} else if expMap := map[string]interface{}{"greet_lang": "en", "your_name": "mario"}; !reflect.DeepEqual(aMap, expMap) {
-		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
-	}
+	values := map[string]bool{"yes": true, "On": true, "TRUE": true, "1": true, "no": false, "OFF": false, "false": false, "0": false}
+	for value, expected := range values {
+		os.Args = []string{ProjectName, "--color=" + value}
+		aMap, err := parser.Parse()
+		if err != nil {
+			t.Error(err)
+		} else if expMap := map[string]interface{}{"color": expected}; !reflect.DeepEqual(aMap, expMap) {
+			t.Errorf("Wrong returned map: expected %v, got %v", expMap, aMap)
+		}
+	}
+
+	os.Args = []string{ProjectName, "-c=maybe"}
+	_, err := parser.Parse()
+	if err == nil {
+		t.Errorf("Expecting error, got nil")
+	} else if !strings.Contains(err.Error(), "accepted: true, false, yes, no, on, off, 1, 0") {
+		t.Errorf("Wrong error message: got %s", err)
+	}
+}
+
+func TestCorrectBoolFlag_Negatable(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "color", Short: "c", Negatable: true, Default: true})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "no-cache", Negatable: true})
+
+	tests := []struct {
+		args     []string
+		expected map[string]interface{}
+	}{
+		{[]string{}, map[string]interface{}{"color": true}},
+		{[]string{"--no-color"}, map[string]interface{}{"color": false}},
+		{[]string{"--no-color=false"}, map[string]interface{}{"color": true}},
+		{[]string{"-c", "--no-cache"}, map[string]interface{}{"color": true, "no-cache": true}},
+		{[]string{"--no-no-cache"}, map[string]interface{}{"color": true, "no-cache": false}},
+	}
+	for _, test := range tests {
+		aMap, err := parser.ParseFrom(test.args)
+		if err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(aMap, test.expected) {
+			t.Errorf("Wrong returned map for %v: expected %v, got %v", test.args, test.expected, aMap)
+		}
+	}
+
+	help := parser.GenerateHelp()
+	if !strings.Contains(help, "-c, --color, --no-color") {
+		t.Errorf("Expected the negated flag in the help, got:\n%s", help)
+	}
+	if err := parser.NewBoolFlag(argmap.BoolFlag{Short: "x", Negatable: true}); err == nil {
+		t.Errorf("Expecting error for a negatable flag without a name, got nil")
+	}
+	if err := parser.NewBoolFlag(argmap.BoolFlag{Name: "no-color"}); err == nil || !strings.Contains(err.Error(), "'--no-color' already exists") {
+		t.Errorf("Expecting a representation error, got %v", err)
+	}
+
+	// The negation is decided by the matched representation, not by the token
+	parser = argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "color", Negatable: true, Default: true})
+	parser.SetAbbreviations(true)
+	parser.SetSingleDashLong(true)
+	for _, args := range [][]string{{"--no"}, {"--no-c"}, {"-no-color"}} {
+		if aMap, err := parser.ParseFrom(args); err != nil {
+			t.Error(err)
+		} else if argmap.GetBool(aMap, "color") {
+			t.Errorf("Expected the color to be disabled by %v", args)
+		}
+	}
+	if aMap, err := parser.ParseFrom([]string{"--col"}); err != nil || !argmap.GetBool(aMap, "color") {
+		t.Errorf("Expected the color to be enabled by '--col', got %v (%v)", aMap, err)
+	}
+
+	// A prefix of both the forms of a flag is ambiguous
+	parser = argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "nothing", Negatable: true})
+	parser.SetAbbreviations(true)
+	if aMap, err := parser.ParseFrom([]string{"--no-n"}); err != nil || argmap.GetBool(aMap, "nothing") {
+		t.Errorf("Expected 'nothing' to be false, got %v (%v)", aMap, err)
+	}
+	if _, err := parser.ParseFrom([]string{"--no"}); err == nil {
+		t.Errorf("Expecting an ambiguity error, got nil")
+	}
+}
+
+func TestCorrectCountFlag(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewCountFlag(argmap.CountFlag{Name: "verbose", Short: "v"})
+	parser.NewBoolFlag(argmap.BoolFlag{Short: "q"})
+
+	tests := []struct {
+		args  []string
+		count int
+	}{
+		{[]string{}, 0},
+		{[]string{"-v"}, 1},
+		{[]string{"-v", "--verbose"}, 2},
+		{[]string{"-vvv"}, 3},
+		{[]string{"-vqv"}, 2},
+	}
+	for _, test := range tests {
+		aMap, err := parser.ParseFrom(test.args)
+		if err != nil {
+			t.Error(err)
+		} else if count := argmap.GetCount(aMap, "verbose"); count != test.count {
+			t.Errorf("Wrong count for %v: expected %d, got %d", test.args, test.count, count)
+		}
+	}
+
+	if aMap, _ := parser.ParseFrom([]string{"-vqv"}); !argmap.GetBool(aMap, "q") {
+		t.Errorf("Bunched BoolFlag not set")
+	}
+	if _, err := parser.ParseFrom([]string{"-vx"}); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+}
+
+func TestCorrectStringFlag_Attached(t *testing.T) {
+	parser := argmap.NewArgsParser("Attached test", "")
+	parser.NewStringFlag(argmap.StringFlag{Name: "output", Short: "o"})
+	parser.NewStringFlag(argmap.StringFlag{Name: "pair", Short: "p", NArgs: 2})
+	parser.NewBoolFlag(argmap.BoolFlag{Short: "q"})
+	parser.NewCountFlag(argmap.CountFlag{Name: "verbose", Short: "v"})
+
+	tests := []struct {
+		args   []string
+		output string
+		count  int
+		quiet  bool
+	}{
+		{[]string{"-ojack"}, "jack", 0, false},
+		{[]string{"-vofile"}, "file", 1, false},
+		{[]string{"-vvqo-"}, "-", 2, true},
+		{[]string{"-vo", "file"}, "file", 1, false},
+	}
+	for _, test := range tests {
+		aMap, err := parser.ParseFrom(test.args)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if output := argmap.GetString(aMap, "output"); output != test.output {
+			t.Errorf("Wrong value for %v: expected '%s', got '%s'", test.args, test.output, output)
+		}
+		if count := argmap.GetCount(aMap, "verbose"); count != test.count {
+			t.Errorf("Wrong count for %v: expected %d, got %d", test.args, test.count, count)
+		}
+		if quiet := argmap.GetBool(aMap, "q"); quiet != test.quiet {
+			t.Errorf("Wrong bool for %v: expected %t, got %t", test.args, test.quiet, quiet)
+		}
+	}
+
+	// Flags taking more values can't have an attached one
+	if _, err := parser.ParseFrom([]string{"-pab", "c"}); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+}
+
+/**********************************************************************/
+/*** POSITIONAL ARGUMENTS *********************************************/
+/**********************************************************************/
+func TestCorrectPositional_Required(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	err := parser.NewPositionalArg(argmap.PositionalArg{Name: "your_name", Required: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	os.Args = []string{ProjectName, "mario"}
+	aMap, err := parser.Parse()
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"your_name": "mario"}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
+}
+
+func TestWrongPositional_Required(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	err := parser.NewPositionalArg(argmap.PositionalArg{Name: "your_name", Required: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	os.Args = []string{ProjectName}
+	_, err = parser.Parse()
+	if err == nil || err.Error()[:len(ERRORMissingPositional)] != ERRORMissingPositional {
+		t.Errorf("Expecting error, got nil or wrong one")
+	}
+}
+
+func TestCorrectPositional_Optional(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "your_name", Required: true})
+	err := parser.NewPositionalArg(argmap.PositionalArg{Name: "greet_lang"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	os.Args = []string{ProjectName, "mario"}
+	aMap, err := parser.Parse()
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"your_name": "mario"}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
+
+	os.Args = []string{ProjectName, "mario", "spanish"}
+	aMap, err = parser.Parse()
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"your_name": "mario", "greet_lang": "spanish"}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
+}
+
+func TestCorrectPositional_TwoRequiredOneOptional(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "greet_lang", Required: true})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "your_surname"})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "your_name", Required: true})
+
+	os.Args = []string{ProjectName, "en", "mario"}
+	aMap, err := parser.Parse()
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"greet_lang": "en", "your_name": "mario"}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
 
 	os.Args = []string{ProjectName, "en", "mario", "kart"}
 	aMap, err = parser.Parse()
@@ -408,6 +1015,202 @@ func TestCorrectPositional_TwoRequiredOneOptional(t *testing.T) {
 	}
 }
 
+func TestCorrectPositional_Literal(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "pattern", Required: true, Literal: true})
+	parser.NewBoolFlag(argmap.BoolFlag{Short: "v"})
+	parser.NewBoolFlag(argmap.BoolFlag{Short: "i"})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose"})
+	parser.SetSuggestions(true)
+
+	// The flag-like token fills the expected literal positional, without suggestions
+	os.Args = []string{ProjectName, "--verbos"}
+	aMap, err := parser.Parse()
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"pattern": "--verbos"}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
+
+	// The registered flags keep the precedence, even if bunched
+	tests := []struct {
+		args     []string
+		expected map[string]interface{}
+	}{
+		{[]string{"-i", "foo"}, map[string]interface{}{"i": true, "pattern": "foo"}},
+		{[]string{"-foo", "-v"}, map[string]interface{}{"pattern": "-foo", "v": true}},
+		{[]string{"-vi", "-x"}, map[string]interface{}{"v": true, "i": true, "pattern": "-x"}},
+		{[]string{"--", "-v"}, map[string]interface{}{"pattern": "-v"}},
+	}
+	for _, test := range tests {
+		aMap, err := parser.ParseFrom(test.args)
+		if err != nil {
+			t.Errorf("Unexpected error for %v: %s", test.args, err)
+		} else if !reflect.DeepEqual(aMap, test.expected) {
+			t.Errorf("Wrong returned map for %v: expected %v, got %v", test.args, test.expected, aMap)
+		}
+	}
+	if _, err := parser.ParseFrom([]string{"-v"}); err == nil {
+		t.Errorf("Expecting a missing positional error, got nil")
+	}
+}
+
+func TestCorrectPositional_Kind(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "count", Required: true, Kind: argmap.KindInt})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "ratio", Required: true, Kind: argmap.KindFloat})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "enabled", Required: true, Kind: argmap.KindBool})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "label"})
+
+	os.Args = []string{ProjectName, "3", "0.5", "yes", "x"}
+	aMap, err := parser.Parse()
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"count": 3, "ratio": 0.5, "enabled": true, "label": "x"}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %v, got %v", expMap, aMap)
+	} else if v, err := argmap.GetTypedPositional(aMap, "count"); err != nil || v != 3 {
+		t.Errorf("Wrong typed positional: got %v, %v", v, err)
+	}
+
+	os.Args = []string{ProjectName, "three", "0.5", "yes"}
+	_, err = parser.Parse()
+	if err == nil {
+		t.Errorf("Expecting error, got nil")
+	} else if !strings.Contains(err.Error(), "'count'") {
+		t.Errorf("Wrong error message: got %s", err)
+	}
+}
+
+func TestCorrectPositional_Terminator(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "file", Required: true})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "other"})
+	parser.NewBoolFlag(argmap.BoolFlag{Short: "v"})
+
+	aMap, err := parser.ParseFrom([]string{"-v", "--", "-v", "-h"})
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"v": true, "file": "-v", "other": "-h"}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
+
+	// Only the first "--" is a terminator
+	aMap, err = parser.ParseFrom([]string{"--", "--"})
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"file": "--"}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
+
+	// The values of a ListFlag end at the terminator too
+	parser.NewListFlag(argmap.ListFlag{Name: "list"})
+	aMap, err = parser.ParseFrom([]string{"--list", "a", "--", "-v"})
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"list": []string{"a"}, "file": "-v"}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
+}
+
+func TestCommandTerminator(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewBoolFlag(argmap.BoolFlag{Short: "v"})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "target"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "run"})
+	cmd.NewBoolFlag(argmap.BoolFlag{Short: "v"})
+	cmd.NewPositionalArg(argmap.PositionalArg{Name: "file"})
+
+	// The terminator inside the command affects only the command
+	aMap, err := parser.ParseFrom([]string{"-v", "run", "--", "-v"})
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"v": true, "run": map[string]interface{}{"file": "-v"}}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
+
+	// A command name after the terminator is a positional
+	aMap, err = parser.ParseFrom([]string{"--", "run"})
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"target": "run"}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
+}
+
+func TestTypedPositionalGetters(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "count"})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "ratio"})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "enabled"})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "typed", Kind: argmap.KindInt})
+
+	aMap, err := parser.ParseFrom([]string{"3", "0.5", "1", "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := argmap.GetIntPositional(aMap, "count"); err != nil || v != 3 {
+		t.Errorf("Wrong int value: got %v, %v", v, err)
+	}
+	if v, err := argmap.GetFloatPositional(aMap, "ratio"); err != nil || v != 0.5 {
+		t.Errorf("Wrong float value: got %v, %v", v, err)
+	}
+	if v, err := argmap.GetBoolPositional(aMap, "enabled"); err != nil || !v {
+		t.Errorf("Wrong bool value: got %v, %v", v, err)
+	}
+	if v, err := argmap.GetIntPositional(aMap, "typed"); err != nil || v != 7 {
+		t.Errorf("Wrong int value: got %v, %v", v, err)
+	}
+
+	if _, err := argmap.GetIntPositional(aMap, "ratio"); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+	if _, err := argmap.GetBoolPositional(aMap, "count"); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+	if _, err := argmap.GetFloatPositional(aMap, "missing"); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+}
+
+func TestCorrectPositional_Variadic(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewBoolFlag(argmap.BoolFlag{Short: "v"})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "dest", Required: true})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "files", Variadic: true})
+
+	aMap, err := parser.ParseFrom([]string{"out", "a.txt", "-v", "b.txt", "c.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dest, _ := argmap.GetPositional(aMap, "dest"); dest != "out" {
+		t.Errorf("Wrong positional: expected out, got %s", dest)
+	}
+	if files, err := argmap.GetVariadic(aMap, "files"); err != nil || !reflect.DeepEqual(files, []string{"a.txt", "b.txt", "c.txt"}) {
+		t.Errorf("Wrong variadic values: got %v, %v", files, err)
+	}
+	if help := parser.GenerateHelp(); !strings.Contains(help, "[files...]") {
+		t.Errorf("Missing variadic positional in help: got %s", help)
+	}
+	for _, a := range parser.GetArgsList() {
+		if expected := map[string]int{"dest": 1, "files": -1}[a.GetID()]; expected != 0 && a.Arity() != expected {
+			t.Errorf("Wrong arity for '%s': expected %d, got %d", a.GetID(), expected, a.Arity())
+		}
+	}
+
+	if err := parser.NewPositionalArg(argmap.PositionalArg{Name: "other"}); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+	if err := parser.NewPositionalArg(argmap.PositionalArg{Name: "more", Variadic: true}); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+}
+
 /**********************************************************************/
 /*** COMMANDS AND SUBCOMMANDS *****************************************/
 /**********************************************************************/
@@ -516,27 +1319,1297 @@ func TestSubcommandArguments(t *testing.T) {
 	}
 }
 
-/**********************************************************************/
-/*** GENERIC INSERTION ERRORS *****************************************/
-/**********************************************************************/
-func TestWrongArgument_ExistingIdentifier(t *testing.T) {
-	parser := argmap.NewArgsParser(ProjectName, t.Name())
-	parser.NewStringFlag(argmap.StringFlag{Short: "hi"})
-	err := parser.NewStringFlag(argmap.StringFlag{Name: "hi"})
-	if err == nil {
-		t.Errorf("Expecting error, got nil")
-	}
-}
+func TestMultiCall(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
 
-func TestWrongArgument_HelpIdentifier(t *testing.T) {
 	parser := argmap.NewArgsParser(ProjectName, t.Name())
-	err := parser.NewBoolFlag(argmap.BoolFlag{Name: "help"})
-	if err == nil {
-		t.Errorf("Expecting error, got nil")
-	}
-}
+	parser.NewBoolFlag(argmap.BoolFlag{Short: "v"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "run"})
+	cmd.NewPositionalArg(argmap.PositionalArg{Name: "target"})
+	parser.SetMultiCall(true)
 
-func TestWrongArgument_ExistingRepresentation(t *testing.T) {
+	os.Args = []string{"/usr/local/bin/run", "fast"}
+	aMap, err := parser.Parse()
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"run": map[string]interface{}{"target": "fast"}}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
+
+	// Fall back to the normal parsing
+	os.Args = []string{"/usr/local/bin/" + ProjectName, "-v", "run"}
+	aMap, err = parser.Parse()
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"v": true, "run": map[string]interface{}{}}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
+}
+
+func TestStopAtCommand(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewBoolFlag(argmap.BoolFlag{Short: "v"})
+	parser.NewIntFlag(argmap.IntFlag{Name: "level", Default: []int{2}})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "run"})
+	cmd.NewBoolFlag(argmap.BoolFlag{Name: "fast"})
+	parser.SetStopAtCommand(true)
+
+	os.Args = []string{ProjectName, "-v", "run", "--fast", "--unknown", "-h"}
+	aMap, err := parser.Parse()
+	expMap := map[string]interface{}{"v": true, "level": []int{2}, "run": map[string]interface{}{}, argmap.RestKey: []string{"--fast", "--unknown", "-h"}}
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
+
+	// The program flags are still checked
+	parser.NewStringFlag(argmap.StringFlag{Name: "host", Required: true})
+	if _, err = parser.ParseFrom([]string{"run", "--fast"}); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+}
+
+func TestAbbreviations(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose"})
+	parser.NewStringFlag(argmap.StringFlag{Name: "output", Short: "o"})
+	parser.NewCommand(argmap.CommandParams{Name: "build"})
+	parser.NewCommand(argmap.CommandParams{Name: "version"})
+	parser.SetAbbreviations(true)
+
+	os.Args = []string{ProjectName, "--verb", "--out", "file", "bu"}
+	aMap, err := parser.Parse()
+	expMap := map[string]interface{}{"verbose": true, "output": []string{"file"}, "build": map[string]interface{}{}}
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
+
+	// "--ver" matches both the --verbose flag and the version command
+	os.Args = []string{ProjectName, "--ver"}
+	_, err = parser.Parse()
+	if err == nil {
+		t.Errorf("Expecting error, got nil")
+	} else if exp := "Error: ambiguous argument '--ver' (could be: --verbose, version)"; err.Error() != exp {
+		t.Errorf("Wrong error message: expected %s, got %s", exp, err)
+	}
+}
+
+func TestSuggestCommands(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "target"})
+	parser.NewCommand(argmap.CommandParams{Name: "build"})
+
+	// Default behavior: the token is stored as a positional
+	os.Args = []string{ProjectName, "--buidl"}
+	if _, err := parser.Parse(); err != nil {
+		t.Error(err)
+	}
+
+	parser.SetSuggestCommands(true)
+	_, err := parser.Parse()
+	if err == nil {
+		t.Errorf("Expecting error, got nil")
+	} else if exp := "Error: unknown flag '--buidl'; did you mean the command 'build'?"; err.Error() != exp {
+		t.Errorf("Wrong error message: expected %s, got %s", exp, err)
+	}
+
+	os.Args = []string{ProjectName, "--something"}
+	if _, err := parser.Parse(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseWithDefaults(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "hello"})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "spanish"})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "name", Required: true})
+
+	presets := map[string]interface{}{"hello": []string{"world"}, "spanish": true, "name": "jack"}
+	aMap, err := parser.ParseWithDefaults([]string{"--hello", "mario"}, presets)
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"hello": []string{"mario"}, "spanish": true, "name": "jack"}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
+
+	if presets["hello"].([]string)[0] != "world" {
+		t.Errorf("The presets map has been modified")
+	}
+}
+
+func TestDeprecatedCommand(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "old", Help: "old command", Deprecated: "use 'new' instead"})
+	cmd.NewBoolFlag(argmap.BoolFlag{Short: "v"})
+	parser.NewCommand(argmap.CommandParams{Name: "new"})
+
+	os.Args = []string{ProjectName, "old", "-v"}
+	aMap, err := parser.Parse()
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"old": map[string]interface{}{"v": true}}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
+
+	expWarnings := []string{"Warning: command 'old' is deprecated: use 'new' instead"}
+	if warnings := parser.Warnings(); !reflect.DeepEqual(warnings, expWarnings) {
+		t.Errorf("Wrong warnings: expected %s, got %s", expWarnings, warnings)
+	}
+	if help := parser.GenerateHelp(); !strings.Contains(help, "old command (deprecated)") {
+		t.Errorf("Missing deprecation in help: got %s", help)
+	}
+
+	os.Args = []string{ProjectName, "new"}
+	parser.Parse()
+	if warnings := parser.Warnings(); len(warnings) != 0 {
+		t.Errorf("Unexpected warnings: got %s", warnings)
+	}
+}
+
+func TestEnvPrefix(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "host"})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "dry-run"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "db"})
+	cmd.NewStringFlag(argmap.StringFlag{Name: "host"})
+	cmd.NewListFlag(argmap.ListFlag{Name: "tables"})
+	parser.SetEnvPrefix("MYTOOL")
+
+	os.Setenv("MYTOOL_HOST", "global.example")
+	os.Setenv("MYTOOL_DRY_RUN", "yes")
+	os.Setenv("MYTOOL_DB_HOST", "db.example")
+	os.Setenv("MYTOOL_DB_TABLES", "users orders")
+	defer func() {
+		for _, name := range []string{"MYTOOL_HOST", "MYTOOL_DRY_RUN", "MYTOOL_DB_HOST", "MYTOOL_DB_TABLES"} {
+			os.Unsetenv(name)
+		}
+	}()
+
+	os.Args = []string{ProjectName, "--host", "cli.example", "db"}
+	aMap, err := parser.Parse()
+	expMap := map[string]interface{}{
+		"host":    []string{"cli.example"},
+		"dry-run": true,
+		"db":      map[string]interface{}{"host": []string{"db.example"}, "tables": []string{"users", "orders"}},
+	}
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %v, got %v", expMap, aMap)
+	}
+}
+
+func TestFlagEnv(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "host", Env: "TEST_ARGMAP_HOST"})
+	parser.NewIntFlag(argmap.IntFlag{Name: "port", Env: "TEST_ARGMAP_PORT", Default: []int{80}})
+	defer os.Unsetenv("TEST_ARGMAP_HOST")
+	defer os.Unsetenv("TEST_ARGMAP_PORT")
+
+	tests := []struct {
+		host, port string
+		args       []string
+		expMap     map[string]interface{}
+	}{
+		// unset variables: Default only
+		{"", "", []string{}, map[string]interface{}{"port": []int{80}}},
+		// empty variables are ignored as if unset
+		{"", "", []string{}, map[string]interface{}{"port": []int{80}}},
+		// env overrides Default
+		{"env.example", "8080", []string{}, map[string]interface{}{"host": []string{"env.example"}, "port": []int{8080}}},
+		// command line overrides env
+		{"env.example", "8080", []string{"--host", "cli.example", "--port", "9000"}, map[string]interface{}{"host": []string{"cli.example"}, "port": []int{9000}}},
+	}
+	for i, test := range tests {
+		os.Unsetenv("TEST_ARGMAP_HOST")
+		os.Unsetenv("TEST_ARGMAP_PORT")
+		if i > 0 {
+			os.Setenv("TEST_ARGMAP_HOST", test.host)
+			os.Setenv("TEST_ARGMAP_PORT", test.port)
+		}
+
+		aMap, err := parser.ParseFrom(test.args)
+		if err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(aMap, test.expMap) {
+			t.Errorf("Wrong returned map (test %d): expected %v, got %v", i, test.expMap, aMap)
+		}
+	}
+
+	os.Setenv("TEST_ARGMAP_PORT", "http")
+	if _, err := parser.ParseFrom([]string{}); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+}
+
+func TestCaseInsensitiveCommands(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose"})
+	parser.NewCommand(argmap.CommandParams{Name: "run"})
+
+	if _, err := parser.ParseFrom([]string{"Run"}); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+
+	parser.SetCaseInsensitiveCommands(true)
+	for _, name := range []string{"run", "Run", "RUN"} {
+		aMap, err := parser.ParseFrom([]string{name})
+		if err != nil {
+			t.Error(err)
+		} else if cmdName, _, err := argmap.GetCommandMap(aMap); err != nil || cmdName != "run" {
+			t.Errorf("Command '%s' not stored as 'run': got %v", name, aMap)
+		}
+	}
+
+	if _, err := parser.ParseFrom([]string{"--VERBOSE"}); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+}
+
+func TestParseGlobal(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "context"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "get"})
+	cmd.NewBoolFlag(argmap.BoolFlag{Short: "w"})
+
+	globals, command, rest, err := parser.ParseGlobal([]string{"--context", "prod", "get", "pods", "-w", "--other"})
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"context": []string{"prod"}}; !reflect.DeepEqual(globals, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, globals)
+	} else if command != "get" || !reflect.DeepEqual(rest, []string{"pods", "-w", "--other"}) {
+		t.Errorf("Wrong command or rest: got %s, %s", command, rest)
+	}
+
+	_, command, rest, err = parser.ParseGlobal([]string{"--context", "prod"})
+	if err != nil {
+		t.Error(err)
+	} else if command != "" || len(rest) != 0 {
+		t.Errorf("Unexpected command or rest: got %s, %s", command, rest)
+	}
+
+	// The globals are validated and get their defaults before the command is dispatched
+	parser = argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "host", Required: true})
+	parser.NewIntFlag(argmap.IntFlag{Name: "port", Default: []int{8080}})
+	parser.NewCommand(argmap.CommandParams{Name: "run"})
+
+	if _, _, _, err = parser.ParseGlobal([]string{"run", "x"}); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+	globals, command, rest, err = parser.ParseGlobal([]string{"--host", "local", "run", "x"})
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"host": []string{"local"}, "port": []int{8080}}; !reflect.DeepEqual(globals, expMap) {
+		t.Errorf("Wrong returned map: expected %v, got %v", expMap, globals)
+	} else if command != "run" || !reflect.DeepEqual(rest, []string{"x"}) {
+		t.Errorf("Wrong command or rest: got %s, %s", command, rest)
+	}
+}
+
+func TestParseReader(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "hello", NArgs: 2})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "file"})
+
+	input := strings.NewReader("--hello \"Jack Bond\" 'it''s' my\\ file.txt\n--hello a b\n")
+	aMap, err := parser.ParseReader(input)
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"hello": []string{"Jack Bond", "its"}, "file": "my file.txt"}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
+
+	// The following lines are still available in the reader, even if it doesn't read by bytes
+	if aMap, err = parser.ParseReader(input); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(aMap["hello"], []string{"a", "b"}) {
+		t.Errorf("Second line not parsed: got %v", aMap)
+	}
+	unbuffered := io.MultiReader(strings.NewReader("x\ny\n"))
+	for _, expected := range []string{"x", "y"} {
+		if aMap, err = parser.ParseReader(unbuffered); err != nil {
+			t.Error(err)
+		} else if file := aMap["file"]; file != expected {
+			t.Errorf("Wrong line parsed: expected %s, got %s", expected, file)
+		}
+	}
+	if _, err = parser.ParseReader(unbuffered); err != io.EOF {
+		t.Errorf("Expecting EOF, got %v", err)
+	}
+
+	if _, err = parser.ParseReader(strings.NewReader("--hello 'a b\n")); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+	if _, err = parser.ParseReader(strings.NewReader("")); err != io.EOF {
+		t.Errorf("Expecting EOF, got %v", err)
+	}
+}
+
+func TestParseFrom(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "hello", Short: "hi"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "run"})
+	cmd.NewBoolFlag(argmap.BoolFlag{Short: "v"})
+
+	aMap, err := parser.ParseFrom([]string{"-hi", "jack", "run", "-v"})
+	if err != nil {
+		t.Error(err)
+	} else if expMap := map[string]interface{}{"hello": []string{"jack"}, "run": map[string]interface{}{"v": true}}; !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %s, got %s", expMap, aMap)
+	}
+
+	if _, err = parser.ParseFrom([]string{"jill"}); err == nil || err.Error() != ERRORUnrecognized+" 'jill'" {
+		t.Errorf("Wrong error: got %v", err)
+	}
+}
+
+func TestCommandRequiredFlags(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "run"})
+	sub, _ := cmd.NewSubcommand(argmap.CommandParams{Name: "fast"})
+	sub.NewStringFlag(argmap.StringFlag{Name: "out", Short: "o", Required: true})
+	sub.NewBoolFlag(argmap.BoolFlag{Short: "y", Required: true})
+
+	_, err := parser.ParseFrom([]string{"run", "fast", "-y"})
+	if err == nil {
+		t.Errorf("Expecting error, got nil")
+	} else if exp := "Error: missing required flag '--out' for command 'run fast'"; err.Error() != exp {
+		t.Errorf("Wrong error message: expected %s, got %s", exp, err)
+	}
+
+	_, err = parser.ParseFrom([]string{"run", "fast", "-o", "file.txt"})
+	if err == nil {
+		t.Errorf("Expecting error, got nil")
+	} else if exp := "Error: missing required flag '-y' for command 'run fast'"; err.Error() != exp {
+		t.Errorf("Wrong error message: expected %s, got %s", exp, err)
+	}
+
+	if _, err = parser.ParseFrom([]string{"run"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStructuredErrors(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "hello", NArgs: 2})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "pos", Required: true})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "cmd"})
+	sub, _ := cmd.NewSubcommand(argmap.CommandParams{Name: "sub"})
+	sub.NewPositionalArg(argmap.PositionalArg{Name: "file", Required: true})
+
+	_, err := parser.ParseFrom([]string{"a", "b"})
+	var unrecognized argmap.UnrecognizedArgError
+	if !errors.As(err, &unrecognized) || unrecognized.Arg != "b" {
+		t.Errorf("Expecting UnrecognizedArgError, got %v", err)
+	} else if err.Error() != ERRORUnrecognized+" 'b'" {
+		t.Errorf("Wrong error message: got %s", err)
+	}
+
+	_, err = parser.ParseFrom([]string{"a", "--hello", "x"})
+	var usage argmap.IncorrectUsageError
+	if !errors.As(err, &usage) || usage.Flag != "--hello" || usage.Expected != 2 || usage.Available != 1 {
+		t.Errorf("Expecting IncorrectUsageError, got %v", err)
+	}
+
+	_, err = parser.ParseFrom([]string{"a", "cmd", "sub"})
+	var missing argmap.MissingPositionalError
+	var cmdErr argmap.CommandError
+	if !errors.As(err, &missing) || missing.Name != "file" {
+		t.Errorf("Expecting MissingPositionalError, got %v", err)
+	} else if !errors.As(err, &cmdErr) || !reflect.DeepEqual(cmdErr.Path, []string{"cmd", "sub"}) {
+		t.Errorf("Expecting CommandError with path [cmd sub], got %v", err)
+	} else if exp := ERRORMissingPositional + " 'file' for command 'cmd sub'"; err.Error() != exp {
+		t.Errorf("Wrong error message: expected %s, got %s", exp, err)
+	}
+}
+
+func TestExitOnHelpDisabled(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.SetExitOnHelp(false)
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "cmd"})
+
+	if _, err := parser.ParseFrom([]string{"-h"}); err != argmap.ErrHelpRequested {
+		t.Errorf("Expecting ErrHelpRequested, got %v", err)
+	}
+
+	aMap, err := parser.ParseFrom([]string{"cmd", "--help"})
+	if err != argmap.ErrHelpRequested {
+		t.Errorf("Expecting ErrHelpRequested, got %v", err)
+	} else if trace, ok := aMap["trace"].([]*argmap.Command); !ok || len(trace) != 1 || trace[0] != cmd {
+		t.Errorf("Wrong command trace: got %v", aMap["trace"])
+	}
+}
+
+func TestVersionFlag(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.SetExitOnHelp(false)
+	if err := parser.SetVersion("1.2.3"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, flag := range []string{"-V", "--version"} {
+		if _, err := parser.ParseFrom([]string{flag}); err != argmap.ErrVersionRequested {
+			t.Errorf("Expecting ErrVersionRequested for %s, got %v", flag, err)
+		}
+	}
+	if help := parser.GenerateHelp(); !strings.Contains(help, "-V, --version") {
+		t.Errorf("Missing version flag in help: got %s", help)
+	}
+	parser.SetHelpHeaderTemplate("{{.Name}} {{.Version}}")
+	if help := parser.GenerateHelp(); !strings.HasPrefix(help, ProjectName+" 1.2.3\n") {
+		t.Errorf("Expected the version in the help header, got %s", help)
+	}
+	if err := parser.NewBoolFlag(argmap.BoolFlag{Name: "version"}); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+
+	other := argmap.NewArgsParser(ProjectName, t.Name())
+	other.NewBoolFlag(argmap.BoolFlag{Name: "verbose", Short: "V"})
+	if err := other.SetVersion("1.2.3"); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+}
+
+func TestMustParse(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.SetReportHelpOnError(false)
+	parser.SetExitOnError(false)
+	parser.SetErrOutput(&bytes.Buffer{})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "bool"})
+
+	os.Args = []string{ProjectName, "--bool"}
+	if aMap := parser.MustParse(); !argmap.GetBool(aMap, "bool") {
+		t.Errorf("Wrong returned map: got %v", aMap)
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("Expecting panic, got nil")
+		} else if err, ok := r.(error); !ok || err.Error() != ERRORUnrecognized+" 'jill'" {
+			t.Errorf("Wrong panic value: got %v", r)
+		}
+	}()
+	os.Args = []string{ProjectName, "jill"}
+	parser.MustParse()
+}
+
+func TestExecute(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	printer, _ := parser.NewCommand(argmap.CommandParams{Name: "print"})
+	file, _ := printer.NewSubcommand(argmap.CommandParams{Name: "file"})
+	file.NewPositionalArg(argmap.PositionalArg{Name: "path", Required: true})
+	printer.NewSubcommand(argmap.CommandParams{Name: "string"})
+
+	called := ""
+	parser.SetHandler(func(m map[string]interface{}) error {
+		called = "root"
+		return nil
+	})
+	file.SetHandler(func(m map[string]interface{}) error {
+		called, _ = argmap.GetPositional(m, "path")
+		return nil
+	})
+
+	tests := []struct {
+		args   []string
+		called string
+		fails  bool
+	}{
+		{[]string{}, "root", false},
+		{[]string{"print", "file", "a.txt"}, "a.txt", false},
+		{[]string{"print", "string"}, "", true},
+	}
+	for _, test := range tests {
+		called = ""
+		aMap, err := parser.ParseFrom(test.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = parser.Execute(aMap)
+		if (err != nil) != test.fails {
+			t.Errorf("Wrong error for %v: got %v", test.args, err)
+		} else if called != test.called {
+			t.Errorf("Wrong handler for %v: expected '%s', got '%s'", test.args, test.called, called)
+		}
+	}
+}
+
+func TestGetCommandPath(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "bool"})
+	printer, _ := parser.NewCommand(argmap.CommandParams{Name: "print"})
+	file, _ := printer.NewSubcommand(argmap.CommandParams{Name: "file"})
+	file.NewPositionalArg(argmap.PositionalArg{Name: "path"})
+
+	tests := []struct {
+		args []string
+		path []string
+	}{
+		{[]string{"--bool"}, []string{}},
+		{[]string{"print"}, []string{"print"}},
+		{[]string{"--bool", "print", "file", "a.txt"}, []string{"print", "file"}},
+	}
+	for _, test := range tests {
+		aMap, err := parser.ParseFrom(test.args)
+		if err != nil {
+			t.Error(err)
+		} else if path := argmap.GetCommandPath(aMap); !reflect.DeepEqual(path, test.path) {
+			t.Errorf("Wrong command path: expected %v, got %v", test.path, path)
+		}
+	}
+}
+
+func TestRequire(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.SetExitOnHelp(false)
+	parser.SetVersion("1.0")
+	parser.NewIntFlag(argmap.IntFlag{Name: "port"})
+	parser.NewStringFlag(argmap.StringFlag{Name: "host", Required: true})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "file"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "run"})
+	cmd.NewBoolFlag(argmap.BoolFlag{Name: "fast"})
+
+	if err := parser.Require("port", "file"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := parser.Require("unknown"); err == nil {
+		t.Errorf("Expecting error for an unknown identifier, got nil")
+	}
+	cmd.Require("fast")
+
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"--host", "h", "--port", "80", "a.txt"}, ""},
+		{[]string{"--port", "80", "a.txt"}, "Error: missing required flag '--host'"},
+		{[]string{"--host", "h", "a.txt"}, "Error: missing required argument 'port'"},
+		{[]string{"--host", "h", "--port", "80"}, "Error: missing required argument 'file'"},
+		{[]string{"--host", "h", "--port", "80", "run"}, "Error: missing required argument 'fast' for command 'run'"},
+	}
+	for _, test := range tests {
+		_, err := parser.ParseFrom(test.args)
+		if test.err == "" && err != nil {
+			t.Errorf("Unexpected error for %v: %s", test.args, err)
+		} else if test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("Wrong error for %v: expected '%s', got %v", test.args, test.err, err)
+		}
+	}
+
+	var missing argmap.MissingArgumentError
+	if _, err := parser.ParseFrom([]string{"--host", "h", "a.txt"}); !errors.As(err, &missing) || missing.Key != "port" {
+		t.Errorf("Expected a MissingArgumentError for 'port', got %v", err)
+	}
+	if _, err := parser.ParseFrom([]string{"--version"}); err != argmap.ErrVersionRequested {
+		t.Errorf("Expected ErrVersionRequested, got %v", err)
+	}
+}
+
+func TestDescribeCLI(t *testing.T) {
+	parser := argmap.NewArgsParser("prog", "does things")
+	parser.NewChoiceFlag(argmap.ChoiceFlag{Name: "mode", Short: "m", Choices: []string{"fast", "slow"}, Help: "run mode"})
+	parser.NewListFlag(argmap.ListFlag{Name: "tags", Required: true, Hidden: true})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "count", Kind: argmap.KindInt, Required: true})
+	printer, _ := parser.NewCommand(argmap.CommandParams{Name: "print", Help: "prints", Aliases: []string{"p"}})
+	printer.SetSubcommandRequired(true)
+	file, _ := printer.NewSubcommand(argmap.CommandParams{Name: "file", Deprecated: "use cat"})
+	file.NewStringFlag(argmap.StringFlag{Short: "o", NArgs: 2})
+
+	expected := argmap.CLISpec{
+		Name:        "prog",
+		Description: "does things",
+		Flags: []argmap.FlagSpec{
+			{Name: "mode", Short: "m", Help: "run mode", Type: "choice", NArgs: 1, Choices: []string{"fast", "slow"}},
+			{Name: "tags", Type: "list", NArgs: -1, Required: true, Hidden: true},
+			{Name: "help", Short: "h", Help: "shows help message and exits", Type: "help"},
+		},
+		Positionals: []argmap.PositionalSpec{{Name: "count", Kind: "int", Required: true}},
+		Commands: []argmap.CommandSpec{{
+			Name:               "print",
+			Aliases:            []string{"p"},
+			Help:               "prints",
+			SubcommandRequired: true,
+			Flags:              []argmap.FlagSpec{{Name: "help", Short: "h", Help: "shows command help and exits", Type: "help"}},
+			Positionals:        []argmap.PositionalSpec{},
+			Commands: []argmap.CommandSpec{{
+				Name:       "file",
+				Aliases:    []string{},
+				Deprecated: "use cat",
+				Flags: []argmap.FlagSpec{
+					{Short: "o", Type: "string", NArgs: 2},
+					{Name: "help", Short: "h", Help: "shows command help and exits", Type: "help"},
+				},
+				Positionals: []argmap.PositionalSpec{},
+				Commands:    []argmap.CommandSpec{},
+			}},
+		}},
+	}
+	if spec := parser.DescribeCLI(); !reflect.DeepEqual(spec, expected) {
+		t.Errorf("Wrong description:\nexpected %+v\ngot      %+v", expected, spec)
+	}
+}
+
+func TestCaptureRest(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewBoolFlag(argmap.BoolFlag{Short: "x"})
+	exec, _ := parser.NewCommand(argmap.CommandParams{Name: "exec"})
+	exec.NewIntFlag(argmap.IntFlag{Name: "timeout"})
+	exec.NewPositionalArg(argmap.PositionalArg{Name: "cmd", Required: true})
+	if err := exec.SetCaptureRest("args"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := exec.SetCaptureRest("cmd"); err == nil {
+		t.Errorf("Expecting error for an existing identifier, got nil")
+	}
+	run, _ := parser.NewCommand(argmap.CommandParams{Name: "run"})
+	run.NewBoolFlag(argmap.BoolFlag{Name: "verbose"})
+	run.SetCaptureRest("args")
+
+	tests := []struct {
+		args     []string
+		expected map[string]interface{}
+	}{
+		{[]string{"exec", "--timeout", "5", "ls", "-x", "--timeout", "-la"}, map[string]interface{}{
+			"exec": map[string]interface{}{"timeout": []int{5}, "cmd": "ls", "args": []string{"-x", "--timeout", "-la"}}}},
+		{[]string{"-x", "exec", "ls"}, map[string]interface{}{
+			"x": true, "exec": map[string]interface{}{"cmd": "ls", "args": []string{}}}},
+		{[]string{"exec", "ls", "--", "-x"}, map[string]interface{}{
+			"exec": map[string]interface{}{"cmd": "ls", "args": []string{"-x"}}}},
+		{[]string{"exec", "ls", "-x", "--"}, map[string]interface{}{
+			"exec": map[string]interface{}{"cmd": "ls", "args": []string{"-x", "--"}}}},
+		{[]string{"run", "--verbose", "--", "-x", "--verbose"}, map[string]interface{}{
+			"run": map[string]interface{}{"verbose": true, "args": []string{"-x", "--verbose"}}}},
+		{[]string{"run", "script.sh", "-x"}, map[string]interface{}{
+			"run": map[string]interface{}{"args": []string{"script.sh", "-x"}}}},
+	}
+	for _, test := range tests {
+		aMap, err := parser.ParseFrom(test.args)
+		if err != nil {
+			t.Errorf("Unexpected error for %v: %s", test.args, err)
+		} else if !reflect.DeepEqual(aMap, test.expected) {
+			t.Errorf("Wrong returned map for %v: expected %v, got %v", test.args, test.expected, aMap)
+		}
+	}
+
+	// The program itself doesn't capture anything
+	if _, err := parser.ParseFrom([]string{"-x", "extra"}); err == nil {
+		t.Errorf("Expecting error for 'extra', got nil")
+	}
+}
+
+func TestExtraPositionalPolicy(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose"})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "file"})
+	run, _ := parser.NewCommand(argmap.CommandParams{Name: "run"})
+	run.NewPositionalArg(argmap.PositionalArg{Name: "script"})
+
+	args := []string{"a.txt", "--unknown", "b.txt", "--verbose", "run", "x.sh", "-q", "--", "--verbose"}
+	if _, err := parser.ParseFrom(args); err == nil || err.Error() != "Error: unrecognized argument '--unknown'" {
+		t.Errorf("Expected an unrecognized argument error, got %v", err)
+	}
+
+	parser.SetExtraPositionalPolicy(argmap.ExtraIgnore)
+	expected := map[string]interface{}{
+		"file":    "a.txt",
+		"verbose": true,
+		"run":     map[string]interface{}{"script": "x.sh"},
+	}
+	if aMap, err := parser.ParseFrom(args); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(aMap, expected) {
+		t.Errorf("Wrong returned map: expected %v, got %v", expected, aMap)
+	}
+
+	parser.SetExtraPositionalPolicy(argmap.ExtraCollect)
+	expected = map[string]interface{}{
+		"file":          "a.txt",
+		"verbose":       true,
+		argmap.ExtraKey: []string{"--unknown", "b.txt"},
+		"run":           map[string]interface{}{"script": "x.sh", argmap.ExtraKey: []string{"-q", "--verbose"}},
+	}
+	if aMap, err := parser.ParseFrom(args); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(aMap, expected) {
+		t.Errorf("Wrong returned map: expected %v, got %v", expected, aMap)
+	}
+}
+
+func TestParseContext(t *testing.T) {
+	validated := 0
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "name", Validate: func(string) error {
+		validated++
+		return nil
+	}})
+
+	aMap, err := parser.ParseContext(context.Background(), []string{"--name", "jack"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if name := argmap.GetString(aMap, "name"); name != "jack" || validated != 1 {
+		t.Errorf("Expected 'jack' validated once, got '%s' validated %d times", name, validated)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := parser.ParseContext(ctx, []string{"--name", "jack"}); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	// A hook cancelling the context stops the following steps
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	parser.SetArgsPreprocessor(func(args []string) ([]string, error) {
+		cancel()
+		return args, nil
+	})
+	if _, err := parser.ParseContext(ctx, []string{"--name", "jack"}); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if validated != 1 {
+		t.Errorf("Expected no more validations after the cancellation, got %d", validated)
+	}
+
+	// The context is passed to the hooks accepting it
+	type key struct{}
+	ctx = context.WithValue(context.Background(), key{}, "value")
+	seen := []interface{}{}
+	parser = argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "name", ValidateContext: func(ctx context.Context, s string) error {
+		seen = append(seen, ctx.Value(key{}))
+		return nil
+	}})
+	parser.SetArgsPreprocessorContext(func(ctx context.Context, args []string) ([]string, error) {
+		seen = append(seen, ctx.Value(key{}))
+		return args, nil
+	})
+	if _, err := parser.ParseContext(ctx, []string{"--name", "jack"}); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(seen, []interface{}{"value", "value"}) {
+		t.Errorf("Expected the context in both hooks, got %v", seen)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := parser.LoadDefaultsFromContext(cancelled, strings.NewReader("name=jack")); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	// The context is not kept by the parser after the parsing
+	seen = nil
+	if _, err := parser.ParseFrom([]string{"--name", "jack"}); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(seen, []interface{}{nil, nil}) {
+		t.Errorf("Expected a context without values, got %v", seen)
+	}
+}
+
+func TestGetSubcommandMap(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	printer, _ := parser.NewCommand(argmap.CommandParams{Name: "print"})
+	printer.NewSubcommand(argmap.CommandParams{Name: "string"})
+	file, _ := printer.NewSubcommand(argmap.CommandParams{Name: "file"})
+	file.NewPositionalArg(argmap.PositionalArg{Name: "path"})
+
+	aMap, err := parser.ParseFrom([]string{"print", "file", "a.txt"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	fileMap, err := argmap.GetSubcommandMap(aMap, "print", "file")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if path, _ := argmap.GetPositional(fileMap, "path"); path != "a.txt" {
+		t.Errorf("Expected 'a.txt', got '%s'", path)
+	}
+
+	_, err = argmap.GetSubcommandMap(aMap, "print", "string")
+	var notInvoked argmap.CommandNotInvokedError
+	if !errors.As(err, &notInvoked) || !reflect.DeepEqual(notInvoked.Path, []string{"print", "string"}) {
+		t.Errorf("Expected a CommandNotInvokedError for 'print string', got %v", err)
+	}
+	if _, err = argmap.GetSubcommandMap(aMap, "hello", "file"); err == nil || err.Error() != "Error: command 'hello' was not invoked" {
+		t.Errorf("Expected an error naming 'hello', got %v", err)
+	}
+}
+
+func TestAddRequires(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "cert"})
+	parser.NewStringFlag(argmap.StringFlag{Name: "key"})
+	parser.NewStringFlag(argmap.StringFlag{Name: "ca"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "serve"})
+	cmd.NewBoolFlag(argmap.BoolFlag{Name: "tls"})
+	cmd.NewStringFlag(argmap.StringFlag{Name: "port"})
+
+	if err := parser.AddRequires("cert", "key", "ca"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.AddRequires("tls", "port"); err != nil {
+		t.Fatal(err)
+	}
+	if err := parser.AddRequires("cert", "missing"); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"--key", "k"}, ""},
+		{[]string{"--cert", "c", "--key", "k", "--ca", "a"}, ""},
+		{[]string{"--cert", "c"}, "Error: flag '--cert' requires '--key'"},
+		{[]string{"--cert", "c", "--key", "k"}, "Error: flag '--cert' requires '--ca'"},
+		{[]string{"serve", "--tls"}, "Error: flag '--tls' requires '--port' for command 'serve'"},
+	}
+	for _, test := range tests {
+		_, err := parser.ParseFrom(test.args)
+		if test.err == "" && err != nil {
+			t.Error(err)
+		} else if test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("Wrong error for %v: expected %s, got %v", test.args, test.err, err)
+		}
+	}
+}
+
+func TestOutputWriters(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	var out, errOut bytes.Buffer
+	parser.SetOutput(&out)
+	parser.SetErrOutput(&errOut)
+	parser.SetExitOnError(false)
+
+	parser.PrintHelp()
+	if help := parser.GenerateHelp() + "\n"; out.String() != help {
+		t.Errorf("Wrong output: expected %s, got %s", help, out.String())
+	}
+
+	out.Reset()
+	parser.ReportError(errors.New("Error: something went wrong"))
+	if !strings.HasPrefix(errOut.String(), "Error: something went wrong\n\n") || !strings.Contains(errOut.String(), t.Name()) {
+		t.Errorf("Wrong error output: got %s", errOut.String())
+	} else if out.Len() != 0 {
+		t.Errorf("Unexpected output: got %s", out.String())
+	}
+}
+
+func TestReportHelpOnError(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	var errOut bytes.Buffer
+	parser.SetErrOutput(&errOut)
+	parser.SetExitOnError(false)
+	parser.SetReportHelpOnError(false)
+
+	_, err := parser.ParseFrom([]string{"--unknown"})
+	parser.ReportError(err)
+	if errOut.String() != err.Error()+"\n" {
+		t.Errorf("Expected only the error message, got %s", errOut.String())
+	}
+
+	errOut.Reset()
+	parser.SetReportHelpOnError(true)
+	parser.ReportError(err)
+	if !strings.HasPrefix(errOut.String(), err.Error()+"\n\n") || !strings.Contains(errOut.String(), t.Name()) {
+		t.Errorf("Expected the error followed by the help, got %s", errOut.String())
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "name"})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "bool"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "cmd"})
+	sub, _ := cmd.NewSubcommand(argmap.CommandParams{Name: "sub"})
+	sub.NewPositionalArg(argmap.PositionalArg{Name: "pos"})
+
+	aMap, err := parser.ParseFrom([]string{"--name", "Jack", "--bool", "cmd", "sub", "value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := argmap.MarshalJSON(aMap)
+	exp := `{"bool":true,"cmd":{"sub":{"pos":"value"}},"name":["Jack"]}`
+	if err != nil {
+		t.Error(err)
+	} else if string(data) != exp {
+		t.Errorf("Wrong JSON: expected %s, got %s", exp, data)
+	}
+
+	data, err = argmap.MarshalJSON(map[string]interface{}{"help": true, "trace": []*argmap.Command{sub, cmd}})
+	if exp = `{"help":true,"trace":["sub","cmd"]}`; err != nil || string(data) != exp {
+		t.Errorf("Wrong JSON: expected %s, got %s, %v", exp, data, err)
+	}
+}
+
+func TestCommandAliases(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "remove", Aliases: []string{"rm", "del"}, Help: "removes files"})
+	cmd.NewSubcommand(argmap.CommandParams{Name: "all", Aliases: []string{"a"}})
+
+	for _, args := range [][]string{{"remove", "all"}, {"rm", "a"}, {"del", "all"}} {
+		aMap, err := parser.ParseFrom(args)
+		if err != nil {
+			t.Error(err)
+		} else if path := argmap.GetCommandPath(aMap); !reflect.DeepEqual(path, []string{"remove", "all"}) {
+			t.Errorf("Wrong command path for %v: got %v", args, path)
+		}
+	}
+
+	if help := parser.GenerateHelp(); !strings.Contains(help, "remove, rm, del") {
+		t.Errorf("Missing aliases in help: got %s", help)
+	}
+	if _, err := parser.NewCommand(argmap.CommandParams{Name: "delete", Aliases: []string{"del"}}); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+	if err := parser.NewBoolFlag(argmap.BoolFlag{Name: "rm"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestArgsPreprocessor(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "new-name"})
+	parser.SetArgsPreprocessor(func(args []string) ([]string, error) {
+		for i, a := range args {
+			if a == "--old-name" {
+				args[i] = "--new-name"
+			} else if a == "@bad" {
+				return nil, errors.New("Error: cannot read 'bad'")
+			}
+		}
+		return args, nil
+	})
+
+	args := []string{"--old-name", "value"}
+	aMap, err := parser.ParseFrom(args)
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(aMap, map[string]interface{}{"new-name": []string{"value"}}) {
+		t.Errorf("Wrong returned map: got %v", aMap)
+	}
+	if args[0] != "--old-name" {
+		t.Errorf("Passed arguments modified: got %v", args)
+	}
+
+	if _, err := parser.ParseFrom([]string{"@bad"}); err == nil || err.Error() != "Error: cannot read 'bad'" {
+		t.Errorf("Wrong error: got %v", err)
+	}
+}
+
+func TestPresentKeysAndDescribe(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "coords", NArgs: 2})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "bool"})
+	parser.NewIntFlag(argmap.IntFlag{Name: "n", Default: []int{3}})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "pos"})
+	parser.NewCommand(argmap.CommandParams{Name: "cmd"})
+
+	aMap, err := parser.ParseFrom([]string{"--coords", "3", "4", "--bool", "value", "cmd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := argmap.PresentKeys(aMap); !reflect.DeepEqual(keys, []string{"bool", "cmd", "coords", "n", "pos"}) {
+		t.Errorf("Wrong keys: got %v", keys)
+	}
+
+	expDesc := map[string]string{"bool": "true", "cmd": "command: cmd", "coords": "3,4", "n": "3", "pos": "value"}
+	if desc := argmap.Describe(aMap); !reflect.DeepEqual(desc, expDesc) {
+		t.Errorf("Wrong description: expected %v, got %v", expDesc, desc)
+	}
+}
+
+func TestCommandTypedFlags(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "cmd"})
+	sub, _ := cmd.NewSubcommand(argmap.CommandParams{Name: "sub"})
+	sub.NewListFlag(argmap.ListFlag{Name: "list"})
+	sub.NewChoiceFlag(argmap.ChoiceFlag{Name: "level", Choices: []string{"low", "high"}})
+	sub.NewIntFlag(argmap.IntFlag{Name: "int", Default: []int{1}})
+	sub.NewFloatFlag(argmap.FloatFlag{Name: "float"})
+	sub.NewDurationFlag(argmap.DurationFlag{Name: "timeout"})
+	sub.NewCountFlag(argmap.CountFlag{Short: "v"})
+
+	aMap, err := parser.ParseFrom([]string{"cmd", "sub", "--level", "high", "--float", "0.5", "--timeout", "1s", "-vv", "--list", "a", "b"})
+	expMap := map[string]interface{}{"cmd": map[string]interface{}{"sub": map[string]interface{}{
+		"list": []string{"a", "b"}, "level": []string{"high"}, "int": []int{1}, "float": []float64{0.5},
+		"timeout": []time.Duration{time.Second}, "v": 2,
+	}}}
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %v, got %v", expMap, aMap)
+	}
+
+	if err := sub.NewIntFlag(argmap.IntFlag{Name: "list"}); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+}
+
+func TestCommandBuilder(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	err := parser.Command("print").Help("prints something").String("o", "output").Bool("v", "verbose").
+		Sub("file").Positional("path", true).Up().
+		Sub("string").Positional("input", true).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aMap, err := parser.ParseFrom([]string{"print", "-v", "file", "a.txt"})
+	expMap := map[string]interface{}{"print": map[string]interface{}{"verbose": true, "file": map[string]interface{}{"path": "a.txt"}}}
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(aMap, expMap) {
+		t.Errorf("Wrong returned map: expected %v, got %v", expMap, aMap)
+	}
+
+	if err := parser.Command("other").Bool("v", "verbose").Bool("v", "").Sub("sub").Build(); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+	if err := parser.Command("print").Build(); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+}
+
+func TestGenerateBashCompletion(t *testing.T) {
+	parser := argmap.NewArgsParser("prog", t.Name())
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose", Short: "v"})
+	parser.NewChoiceFlag(argmap.ChoiceFlag{Name: "level", Choices: []string{"debug", "info"}})
+	printer, _ := parser.NewCommand(argmap.CommandParams{Name: "print", Aliases: []string{"p"}})
+	file, _ := printer.NewSubcommand(argmap.CommandParams{Name: "file"})
+	file.NewStringFlag(argmap.StringFlag{Name: "size", Short: "s", NArgs: 2})
+	file.NewPositionalArg(argmap.PositionalArg{Name: "mode", Required: true})
+	file.NewPositionalArg(argmap.PositionalArg{Name: "path", FileCompletion: true})
+	file.NewPositionalArg(argmap.PositionalArg{Name: "others", Variadic: true, FileCompletion: true})
+
+	script := parser.GenerateBashCompletion()
+	for _, exp := range []string{
+		`" print"|" p") path=" print"; pos=0 ;;`,
+		`" print file") path=" print file"; pos=0 ;;`,
+		`" --level") i=$((i+1)) ;;`,
+		`" print file -s"|" print file --size") i=$((i+2)) ;;`,
+		`opts="--level -v --verbose -h --help print p"`,
+		`--level) COMPREPLY=( $(compgen -W "debug info" -- "$cur") ); return 0 ;;`,
+		"            if ((pos == 1 || pos >= 2)); then\n                files=1\n",
+		"complete -F _prog_completion prog\n",
+	} {
+		if !strings.Contains(script, exp) {
+			t.Errorf("Missing '%s' in completion script:\n%s", exp, script)
+		}
+	}
+
+	var b strings.Builder
+	if err := parser.InstallCompletion(&b); err != nil || b.String() != script {
+		t.Errorf("Wrong installed script: got %v", err)
+	}
+}
+
+func TestCommandInterspersedFlags(t *testing.T) {
+	parser := argmap.NewArgsParser("calc", "")
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "add"})
+	cmd.NewPositionalArg(argmap.PositionalArg{Name: "x", Required: true})
+	cmd.NewPositionalArg(argmap.PositionalArg{Name: "y", Required: true})
+	cmd.NewStringFlag(argmap.StringFlag{Name: "output", Short: "o", NArgs: 1})
+	cmd.NewBoolFlag(argmap.BoolFlag{Name: "verbose", Short: "v"})
+
+	orderings := [][]string{
+		{"add", "-o", "out.txt", "1", "2", "-v"},
+		{"add", "1", "-o", "out.txt", "2", "-v"},
+		{"add", "1", "2", "-o", "out.txt", "-v"},
+		{"add", "-v", "1", "-o", "out.txt", "2"},
+	}
+	var expected map[string]interface{}
+	for _, args := range orderings {
+		aMap, err := parser.ParseFrom(args)
+		if err != nil {
+			t.Fatalf("Unexpected error for %v: %s", args, err)
+		}
+		cMap := aMap["add"].(map[string]interface{})
+		if x, _ := argmap.GetPositional(cMap, "x"); x != "1" {
+			t.Errorf("Expected x to be '1' for %v, got '%s'", args, x)
+		}
+		if y, _ := argmap.GetPositional(cMap, "y"); y != "2" {
+			t.Errorf("Expected y to be '2' for %v, got '%s'", args, y)
+		}
+		if expected == nil {
+			expected = aMap
+		} else if !reflect.DeepEqual(aMap, expected) {
+			t.Errorf("Different maps for %v: %v and %v", args, aMap, expected)
+		}
+	}
+}
+
+func TestSubcommandRequired(t *testing.T) {
+	parser := argmap.NewArgsParser("Printer", "")
+	printer, _ := parser.NewCommand(argmap.CommandParams{Name: "print"})
+	printer.NewSubcommand(argmap.CommandParams{Name: "file"})
+	printer.NewBoolFlag(argmap.BoolFlag{Name: "verbose"})
+
+	if _, err := parser.ParseFrom([]string{"print", "--verbose"}); err != nil {
+		t.Errorf("Expected no error by default, got: %s", err)
+	}
+
+	printer.SetSubcommandRequired(true)
+	_, err := parser.ParseFrom([]string{"print", "--verbose"})
+	if err == nil || err.Error() != "Error: command 'print' requires a subcommand" {
+		t.Errorf("Expected a missing subcommand error, got %v", err)
+	}
+	if _, err := parser.ParseFrom([]string{"print", "file"}); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	// The path of a nested command is complete
+	file, _ := printer.GetSubcommand("file")
+	file.NewSubcommand(argmap.CommandParams{Name: "pdf"})
+	file.SetSubcommandRequired(true)
+	_, err = parser.ParseFrom([]string{"print", "file"})
+	var cmdErr argmap.CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Errorf("Expecting CommandError, got %v", err)
+	} else if !reflect.DeepEqual(cmdErr.Path, []string{"print", "file"}) || err.Error() != "Error: command 'print file' requires a subcommand" {
+		t.Errorf("Wrong error: got %s (path %v)", err, cmdErr.Path)
+	}
+
+	empty, _ := parser.NewCommand(argmap.CommandParams{Name: "empty"})
+	empty.SetSubcommandRequired(true)
+	errs := parser.ValidateSpec()
+	if len(errs) != 1 || errs[0].Error() != "Error: command 'empty' requires a subcommand but has none" {
+		t.Errorf("Unexpected spec errors: %v", errs)
+	}
+}
+
+func TestStrictCommands(t *testing.T) {
+	parser := argmap.NewArgsParser("tool", "")
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose"})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "target"})
+	parser.NewCommand(argmap.CommandParams{Name: "run"})
+	build, _ := parser.NewCommand(argmap.CommandParams{Name: "build"})
+	build.NewSubcommand(argmap.CommandParams{Name: "all"})
+
+	if _, err := parser.ParseFrom([]string{"foo"}); err != nil {
+		t.Errorf("Expected the token to be a positional by default, got: %s", err)
+	}
+
+	parser.SetStrictCommands(true)
+	_, err := parser.ParseFrom([]string{"--verbose", "foo"})
+	var cmdErr argmap.UnknownCommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Name != "foo" {
+		t.Errorf("Expected an UnknownCommandError, got %v", err)
+	} else if err.Error() != "Error: unknown command 'foo' (available: build, run)" {
+		t.Errorf("Unexpected error message: %s", err)
+	}
+
+	_, err = parser.ParseFrom([]string{"build", "some"})
+	if err == nil || err.Error() != "Error: unknown command 'some' (available: all) for command 'build'" {
+		t.Errorf("Expected an unknown subcommand error, got %v", err)
+	}
+	if _, err := parser.ParseFrom([]string{"--verbose", "build", "all"}); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestConcurrentInsertion(t *testing.T) {
+	parser := argmap.NewArgsParser("test", "")
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "run"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			parser.NewBoolFlag(argmap.BoolFlag{Name: "flag" + strconv.Itoa(i)})
+			cmd.NewBoolFlag(argmap.BoolFlag{Name: "flag" + strconv.Itoa(i)})
+		}(i)
+	}
+	wg.Wait()
+
+	if n := len(parser.FlagsByType().BoolFlags); n != 50 {
+		t.Errorf("Expected 50 program flags, got %d", n)
+	}
+	if n := len(cmd.FlagsByType().BoolFlags); n != 50 {
+		t.Errorf("Expected 50 command flags, got %d", n)
+	}
+
+	err := parser.NewBoolFlag(argmap.BoolFlag{Name: "flag7"})
+	if err == nil || err.Error() != "Error: identifier 'flag7' already exists" {
+		t.Errorf("Expected an identifier error, got %v", err)
+	}
+	err = parser.NewBoolFlag(argmap.BoolFlag{Name: "other", Short: "h"})
+	if err == nil || err.Error() != "Error: representation '-h' already exists" {
+		t.Errorf("Expected a representation error, got %v", err)
+	}
+
+	// Removed flags free their identifiers
+	parser.DisableHelpFlag()
+	if err := parser.NewBoolFlag(argmap.BoolFlag{Name: "help", Short: "h"}); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+/**********************************************************************/
+/*** GENERIC INSERTION ERRORS *****************************************/
+/**********************************************************************/
+func TestWrongArgument_ExistingIdentifier(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Short: "hi"})
+	err := parser.NewStringFlag(argmap.StringFlag{Name: "hi"})
+	if err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+}
+
+func TestWrongArgument_HelpIdentifier(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	err := parser.NewBoolFlag(argmap.BoolFlag{Name: "help"})
+	if err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+}
+
+func TestDisableHelpFlag(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.DisableHelpFlag()
+
+	if _, err := parser.ParseFrom([]string{"-h"}); err == nil || err.Error() != ERRORUnrecognized+" '-h'" {
+		t.Errorf("Expecting unrecognized argument, got %v", err)
+	}
+
+	if err := parser.NewBoolFlag(argmap.BoolFlag{Name: "help", Short: "h"}); err != nil {
+		t.Fatal(err)
+	}
+	aMap, err := parser.ParseFrom([]string{"--help"})
+	if err != nil {
+		t.Error(err)
+	} else if !argmap.GetBool(aMap, "help") {
+		t.Errorf("Wrong returned map: got %v", aMap)
+	}
+}
+
+func TestWrongArgument_ExistingRepresentation(t *testing.T) {
 	parser := argmap.NewArgsParser(ProjectName, t.Name())
 	parser.NewStringFlag(argmap.StringFlag{Short: "n"})
 	err := parser.NewStringFlag(argmap.StringFlag{Name: "name", Short: "n"})
@@ -545,6 +2618,565 @@ func TestWrongArgument_ExistingRepresentation(t *testing.T) {
 	}
 }
 
+func TestZshCompletion(t *testing.T) {
+	parser := argmap.NewArgsParser("prog", "")
+	parser.NewStringFlag(argmap.StringFlag{Name: "output", Short: "o", NArgs: 1})
+	parser.NewChoiceFlag(argmap.ChoiceFlag{Name: "level", Choices: []string{"debug", "info"}, Help: "log level"})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose", Help: "be [very] verbose"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "print", Help: "prints things", Aliases: []string{"p"}})
+	cmd.NewPositionalArg(argmap.PositionalArg{Name: "path", FileCompletion: true})
+
+	script := parser.GenerateZshCompletion()
+	for _, expected := range []string{
+		"#compdef prog\n",
+		"'(-o --output)'{-o,--output}'[]:value:'",
+		"'--level[log level]:level:(debug info)'",
+		`'--verbose[be \[very\] verbose]'`,
+		"'print:prints things'",
+		"'p:prints things'",
+		"print|p) _prog_print ;;",
+		"_prog_print() {",
+		"'1:path:_files'",
+		"\n_prog \"$@\"\n",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("Expected '%s' in the completion script:\n%s", expected, script)
+		}
+	}
+}
+
+func TestFlagsByType(t *testing.T) {
+	parser := argmap.NewArgsParser("prog", "")
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose"})
+	parser.NewStringFlag(argmap.StringFlag{Name: "output", NArgs: 1})
+	parser.NewStringFlag(argmap.StringFlag{Name: "input", NArgs: 1})
+	parser.NewListFlag(argmap.ListFlag{Name: "items"})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "path", Required: true})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "print"})
+	cmd.NewCountFlag(argmap.CountFlag{Short: "v"})
+
+	g := parser.FlagsByType()
+	if len(g.StringFlags) != 2 || g.StringFlags[0].Name != "output" || g.StringFlags[1].Name != "input" {
+		t.Errorf("Unexpected StringFlags: %v", g.StringFlags)
+	}
+	if len(g.BoolFlags) != 1 || len(g.ListFlags) != 1 || len(g.Positionals) != 1 || len(g.IntFlags) != 0 {
+		t.Errorf("Unexpected arguments: %v", g)
+	}
+	if len(g.Commands) != 1 || g.Commands[0] != cmd {
+		t.Errorf("Unexpected commands: %v", g.Commands)
+	}
+	if cg := cmd.FlagsByType(); len(cg.CountFlags) != 1 || len(cg.BoolFlags) != 0 {
+		t.Errorf("Unexpected command arguments: %v", cg)
+	}
+}
+
+func TestHelpWidth(t *testing.T) {
+	parser := argmap.NewArgsParser("prog", "")
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose", Help: "shows every single step of the processing in a very detailed way"})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "url", Help: "see https://example.com/a/very/long/path/to/the/documentation"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "print"})
+	cmd.NewBoolFlag(argmap.BoolFlag{Name: "quiet", Help: "prints nothing at all, not even the errors"})
+	parser.SetHelpWidth(40)
+
+	help := parser.GenerateHelp()
+	expected := "  --verbose   shows every single step of\n" +
+		"              the processing in a very\n" +
+		"              detailed way\n"
+	if !strings.Contains(help, expected) {
+		t.Errorf("Expected wrapped description:\n%s\ngot:\n%s", expected, help)
+	}
+	expected = "  --url       see\n              https://example.com/a/very/long/path/to/the/documentation\n"
+	if !strings.Contains(help, expected) {
+		t.Errorf("Expected long word on its own line:\n%s\ngot:\n%s", expected, help)
+	}
+
+	cmdHelp := cmd.GenerateHelp()
+	expected = "    --quiet     prints nothing at all,\n" +
+		"                not even the errors\n"
+	if !strings.Contains(cmdHelp, expected) {
+		t.Errorf("Expected wrapped command description:\n%s\ngot:\n%s", expected, cmdHelp)
+	}
+
+	// The lines of a multi-line description are kept as they are if they fit
+	parser.NewStringFlag(argmap.StringFlag{Name: "mode", Help: "modes:\n  fast  quick\n  slow  careful and very very slow, even slower than that"})
+	help = parser.GenerateHelp()
+	expected = "  --mode value   modes:\n  fast  quick\n  slow careful and very very slow, even\n  slower than that\n"
+	if !strings.Contains(help, expected) {
+		t.Errorf("Expected multi-line description:\n%s\ngot:\n%s", expected, help)
+	}
+
+	parser.SetHelpWidth(-1)
+	if help := parser.GenerateHelp(); !strings.Contains(help, "in a very detailed way\n") {
+		t.Errorf("Expected no wrapping, got:\n%s", help)
+	}
+}
+
+func TestGetString(t *testing.T) {
+	parser := argmap.NewArgsParser("Greeter", "")
+	parser.NewStringFlag(argmap.StringFlag{Name: "hello", NArgs: 2})
+	parser.NewStringFlag(argmap.StringFlag{Name: "bye"})
+	parser.NewListFlag(argmap.ListFlag{Name: "empty"})
+
+	aMap, _ := parser.ParseFrom([]string{"--hello", "Jack", "Jill", "--empty"})
+	if s := argmap.GetString(aMap, "hello"); s != "Jack" {
+		t.Errorf("Expected 'Jack', got '%s'", s)
+	}
+	if s := argmap.GetString(aMap, "bye"); s != "" {
+		t.Errorf("Expected an empty string for an absent flag, got '%s'", s)
+	}
+	if s := argmap.GetString(aMap, "empty"); s != "" {
+		t.Errorf("Expected an empty string for an empty list, got '%s'", s)
+	}
+}
+
+func TestClone(t *testing.T) {
+	parser := argmap.NewArgsParser("prog", "")
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "print"})
+	cmd.NewStringFlag(argmap.StringFlag{Name: "file"})
+
+	clone := parser.Clone()
+	clone.NewBoolFlag(argmap.BoolFlag{Name: "quiet"})
+	cloneCmd := clone.GetArgsList()[2].(*argmap.Command)
+	if cloneCmd == cmd {
+		t.Fatalf("Expected the commands to be cloned")
+	}
+	cloneCmd.NewBoolFlag(argmap.BoolFlag{Name: "all"})
+
+	if len(parser.GetArgsList()) != 3 || len(cmd.GetArgsList()) != 2 {
+		t.Errorf("Expected the original parser to be unchanged")
+	}
+	if _, err := parser.ParseFrom([]string{"--quiet"}); err == nil {
+		t.Errorf("Expected --quiet to be unknown to the original parser")
+	}
+	if _, err := parser.ParseFrom([]string{"print", "--all"}); err == nil {
+		t.Errorf("Expected --all to be unknown to the original command")
+	}
+
+	aMap, err := clone.ParseFrom([]string{"--quiet", "print", "--all", "--file", "a.txt"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	cMap := aMap["print"].(map[string]interface{})
+	if !argmap.GetBool(aMap, "quiet") || !argmap.GetBool(cMap, "all") || argmap.GetString(cMap, "file") != "a.txt" {
+		t.Errorf("Unexpected map from the clone: %v", aMap)
+	}
+}
+
+func TestClone_ConcurrentParsing(t *testing.T) {
+	parser := argmap.NewArgsParser("prog", "")
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "old", Deprecated: "use --new"})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "input"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "print", Deprecated: "use show"})
+	cmd.NewStringFlag(argmap.StringFlag{Name: "file"})
+	parser.ParseFrom([]string{"--old"})
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(clone *argmap.ArgsParser, n int) {
+			defer wg.Done()
+			file := strconv.Itoa(n)
+			aMap, err := clone.ParseFrom([]string{"--old", "print", "--file", file})
+			if err != nil {
+				errs <- err.Error()
+				return
+			}
+			cMap, _ := argmap.GetSubMap(aMap, "print")
+			if argmap.GetString(cMap, "file") != file || len(clone.Warnings()) != 2 {
+				errs <- "Unexpected result from a clone: " + strings.Join(clone.Warnings(), ", ")
+			}
+		}(parser.Clone(), i)
+	}
+	wg.Wait()
+	close(errs)
+	for msg := range errs {
+		t.Error(msg)
+	}
+
+	if len(parser.Warnings()) != 1 {
+		t.Errorf("Expected the warnings of the original parser to be kept, got %v", parser.Warnings())
+	}
+}
+
+func TestFlagSuggestions(t *testing.T) {
+	parser := argmap.NewArgsParser("Greeter", "")
+	parser.NewStringFlag(argmap.StringFlag{Name: "hello"})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "pos"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "print"})
+	cmd.NewBoolFlag(argmap.BoolFlag{Name: "verbose"})
+
+	if _, err := parser.ParseFrom([]string{"--helo"}); err != nil {
+		t.Errorf("Expected no suggestions by default, got: %s", err)
+	}
+
+	parser.SetSuggestions(true)
+	_, err := parser.ParseFrom([]string{"--helo", "Jack"})
+	var unrecErr argmap.UnrecognizedArgError
+	if !errors.As(err, &unrecErr) || unrecErr.Suggestion != "--hello" {
+		t.Errorf("Expected a suggestion for '--hello', got %v", err)
+	} else if err.Error() != "Error: unrecognized argument '--helo', did you mean '--hello'?" {
+		t.Errorf("Unexpected error message: %s", err)
+	}
+
+	_, err = parser.ParseFrom([]string{"print", "--verbsoe"})
+	if err == nil || !strings.Contains(err.Error(), "did you mean '--verbose'?") {
+		t.Errorf("Expected a suggestion inside the command, got %v", err)
+	}
+
+	if _, err := parser.ParseFrom([]string{"--goodbye"}); err != nil {
+		t.Errorf("Expected no suggestions for distant flags, got: %s", err)
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	newParser := func() argmap.ArgsParser {
+		parser := argmap.NewArgsParser("server", "")
+		parser.NewStringFlag(argmap.StringFlag{Name: "host"})
+		parser.NewIntFlag(argmap.IntFlag{Name: "port", Default: []int{80}})
+		parser.NewListFlag(argmap.ListFlag{Name: "tags"})
+		parser.NewBoolFlag(argmap.BoolFlag{Name: "tls"})
+		return parser
+	}
+
+	parser := newParser()
+	config := "# server config\nhost = example.com\nport=8080\ntags=a b c\ntls=true\nunknown=1\n"
+	if err := parser.LoadDefaultsFrom(strings.NewReader(config)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	aMap, err := parser.ParseFrom([]string{"--host", "localhost"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := map[string]interface{}{
+		"host": []string{"localhost"},
+		"port": []int{8080},
+		"tags": []string{"a", "b", "c"},
+		"tls":  true,
+	}
+	if !reflect.DeepEqual(aMap, expected) {
+		t.Errorf("Expected %v, got %v", expected, aMap)
+	}
+
+	parser = newParser()
+	if err := parser.LoadDefaultsFrom(strings.NewReader(`{"port": 9090, "tags": ["x", "y"], "tls": false}`)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	aMap, _ = parser.ParseFrom([]string{})
+	if ports, _ := argmap.GetIntArray(aMap, "port"); !reflect.DeepEqual(ports, []int{9090}) {
+		t.Errorf("Expected the JSON port, got %v", ports)
+	}
+	if tags, _ := argmap.GetList(aMap, "tags"); !reflect.DeepEqual(tags, []string{"x", "y"}) {
+		t.Errorf("Expected the JSON tags, got %v", tags)
+	}
+
+	parser = newParser()
+	if err := parser.LoadDefaultsFrom(strings.NewReader("port=eighty\n")); err == nil || err.Error() != "Error: default value 'eighty' for 'port' is not an integer" {
+		t.Errorf("Expected a type mismatch error, got %v", err)
+	}
+	parser.SetStrictDefaults(true)
+	if err := parser.LoadDefaultsFrom(strings.NewReader("unknown=1\n")); err == nil || err.Error() != "Error: unknown key 'unknown' in defaults" {
+		t.Errorf("Expected an unknown key error, got %v", err)
+	}
+	if err := parser.LoadDefaults("/nonexistent/server.conf"); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+}
+
+func TestGetCommandMap_ConfusableValues(t *testing.T) {
+	parser := argmap.NewArgsParser("prog", "")
+	parser.NewStringFlag(argmap.StringFlag{Name: "labels"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "run"})
+	cmd.NewBoolFlag(argmap.BoolFlag{Name: "fast"})
+	sub, _ := cmd.NewSubcommand(argmap.CommandParams{Name: "job"})
+	sub.NewStringFlag(argmap.StringFlag{Name: "env"})
+	presets := map[string]interface{}{"labels": map[string]interface{}{"env": "prod"}, "zone": nil}
+
+	for i := 0; i < 20; i++ {
+		aMap, err := parser.ParseWithDefaults([]string{"run", "--fast"}, presets)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		name, cmdMap, err := parser.GetCommandMap(aMap)
+		if err != nil || name != "run" || !argmap.GetBool(cmdMap, "fast") {
+			t.Fatalf("Expected the 'run' command, got '%s' %v (%v)", name, cmdMap, err)
+		}
+		if keys := argmap.PresentKeys(aMap); !reflect.DeepEqual(keys, []string{"labels", "run", "zone"}) {
+			t.Errorf("Expected the map to be left untouched, got keys %v", keys)
+		}
+
+		aMap, _ = parser.ParseWithDefaults([]string{}, presets)
+		if name, _, err := parser.GetCommandMap(aMap); err == nil {
+			t.Fatalf("Expected no command, got '%s'", name)
+		}
+	}
+
+	aMap, _ := parser.ParseFrom([]string{"run", "job", "--env", "dev"})
+	cmdMap, _ := argmap.GetSubMap(aMap, "run")
+	if name, subMap, err := cmd.GetCommandMap(cmdMap); err != nil || name != "job" || argmap.GetString(subMap, "env") != "dev" {
+		t.Errorf("Expected the 'job' subcommand, got '%s' %v (%v)", name, subMap, err)
+	}
+	if name, _, _ := argmap.GetCommandMap(map[string]interface{}{"b": nil, "a": map[string]interface{}{}}); name != "a" {
+		t.Errorf("Expected the first command in the order of the keys, got '%s'", name)
+	}
+}
+
+func TestIsPresentPath(t *testing.T) {
+	parser := argmap.NewArgsParser("Printer", "")
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose"})
+	printer, _ := parser.NewCommand(argmap.CommandParams{Name: "print"})
+	file, _ := printer.NewSubcommand(argmap.CommandParams{Name: "file"})
+	file.NewPositionalArg(argmap.PositionalArg{Name: "path", Required: true})
+
+	aMap, _ := parser.ParseFrom([]string{"--verbose", "print", "file", "a.txt"})
+	cases := []struct {
+		keys     []string
+		expected bool
+	}{
+		{[]string{"print", "file", "path"}, true},
+		{[]string{"print", "file"}, true},
+		{[]string{"verbose"}, true},
+		{[]string{"print", "file", "missing"}, false},
+		{[]string{"print", "string", "input"}, false},
+		{[]string{"verbose", "path"}, false},
+		{[]string{}, false},
+	}
+	for _, c := range cases {
+		if argmap.IsPresentPath(aMap, c.keys...) != c.expected {
+			t.Errorf("Expected %t for %v", c.expected, c.keys)
+		}
+	}
+}
+
+func TestSingleDashLong(t *testing.T) {
+	parser := argmap.NewArgsParser("legacy", "")
+	parser.NewStringFlag(argmap.StringFlag{Name: "output", Short: "o"})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "ab"})
+	parser.NewBoolFlag(argmap.BoolFlag{Short: "a"})
+	parser.NewBoolFlag(argmap.BoolFlag{Short: "b"})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "x"})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "extra", Short: "x"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "run"})
+	cmd.NewBoolFlag(argmap.BoolFlag{Name: "fast"})
+
+	if _, err := parser.ParseFrom([]string{"-output", "file"}); err == nil {
+		t.Errorf("Expected single-dash long flags to be disabled by default")
+	}
+
+	parser.SetSingleDashLong(true)
+	aMap, err := parser.ParseFrom([]string{"-output", "file", "-ab", "-x", "run", "-fast"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := map[string]interface{}{
+		"output": []string{"file"},
+		"ab":     true,
+		"extra":  true,
+		"run":    map[string]interface{}{"fast": true},
+	}
+	if !reflect.DeepEqual(aMap, expected) {
+		t.Errorf("Expected %v, got %v", expected, aMap)
+	}
+
+	aMap, _ = parser.ParseFrom([]string{"--output", "file", "-ba"})
+	if !argmap.GetBool(aMap, "a") || !argmap.GetBool(aMap, "b") || argmap.GetString(aMap, "output") != "file" {
+		t.Errorf("Expected double-dash flags and bunching to keep working, got %v", aMap)
+	}
+}
+
+func TestUsageLine(t *testing.T) {
+	parser := argmap.NewArgsParser("prog", "")
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "opt1"})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose"})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "req1", Required: true})
+	if usage := parser.UsageLine(); usage != "prog req1 [opt1] [flags]" {
+		t.Errorf("Unexpected usage line: '%s'", usage)
+	}
+
+	parser.NewCommand(argmap.CommandParams{Name: "run"})
+	if usage := parser.UsageLine(); usage != "prog req1 [opt1] [flags] <command>" {
+		t.Errorf("Unexpected usage line: '%s'", usage)
+	}
+
+	parser = argmap.NewArgsParser("bare", "")
+	parser.DisableHelpFlag()
+	if usage := parser.UsageLine(); usage != "bare" {
+		t.Errorf("Unexpected usage line: '%s'", usage)
+	}
+}
+
+func TestHiddenFlags(t *testing.T) {
+	parser := argmap.NewArgsParser("prog", "")
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose", Help: "shows more"})
+	parser.NewStringFlag(argmap.StringFlag{Name: "debug-dump-everything-to-this-file", Hidden: true})
+	parser.NewCountFlag(argmap.CountFlag{Short: "d", Hidden: true})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "run", Help: "runs"})
+	cmd.NewIntFlag(argmap.IntFlag{Name: "trace-level-for-internal-use", Hidden: true})
+	cmd.NewBoolFlag(argmap.BoolFlag{Name: "fast"})
+
+	help := parser.GenerateHelp()
+	if strings.Contains(help, "debug-dump") || strings.Contains(help, "-d ") {
+		t.Errorf("Expected the hidden flags not to be shown:\n%s", help)
+	}
+	if !strings.Contains(help, "  --verbose   shows more\n") || !strings.Contains(help, "\nCommands:\n  run         runs\n") {
+		t.Errorf("Expected the visible arguments to be aligned as usual:\n%s", help)
+	}
+	if cmdHelp := cmd.GenerateHelp(); strings.Contains(cmdHelp, "trace-level") || !strings.Contains(cmdHelp, "    --fast      \n") {
+		t.Errorf("Expected the hidden command flag not to be shown:\n%s", cmdHelp)
+	}
+
+	aMap, err := parser.ParseFrom([]string{"--debug-dump-everything-to-this-file", "out", "-dd", "run", "--trace-level-for-internal-use", "3"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if argmap.GetString(aMap, "debug-dump-everything-to-this-file") != "out" || argmap.GetCount(aMap, "d") != 2 {
+		t.Errorf("Expected the hidden flags to be parsed, got %v", aMap)
+	}
+}
+
+func TestDeprecatedFlags(t *testing.T) {
+	parser := argmap.NewArgsParser("prog", "")
+	parser.NewStringFlag(argmap.StringFlag{Name: "old", Short: "o", Deprecated: "use '--new'"})
+	parser.NewStringFlag(argmap.StringFlag{Name: "new"})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "quiet", Short: "q", Deprecated: "it is the default"})
+	parser.NewBoolFlag(argmap.BoolFlag{Short: "v"})
+
+	aMap, err := parser.ParseFrom([]string{"-o", "value", "--new", "x", "-vq"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if argmap.GetString(aMap, "old") != "value" || !argmap.GetBool(aMap, "quiet") {
+		t.Errorf("Expected the deprecated flags to be parsed, got %v", aMap)
+	}
+	expected := []string{"Warning: '-o' is deprecated: use '--new'", "Warning: '-q' is deprecated: it is the default"}
+	if w := parser.Warnings(); !reflect.DeepEqual(w, expected) {
+		t.Errorf("Expected warnings %v, got %v", expected, w)
+	}
+
+	var errOut bytes.Buffer
+	parser.SetErrOutput(&errOut)
+	parser.ParseFrom([]string{"--quiet=yes"})
+	if errOut.Len() != 0 {
+		t.Errorf("Expected no output by default, got '%s'", errOut.String())
+	}
+	parser.SetPrintWarnings(true)
+	parser.ParseFrom([]string{"--quiet=yes"})
+	if errOut.String() != "Warning: '--quiet' is deprecated: it is the default\n" {
+		t.Errorf("Unexpected warning output: '%s'", errOut.String())
+	}
+}
+
+func TestGetAll(t *testing.T) {
+	parser := argmap.NewArgsParser("Printer", "")
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose"})
+	printer, _ := parser.NewCommand(argmap.CommandParams{Name: "print"})
+	printer.NewStringFlag(argmap.StringFlag{Name: "encoding"})
+	file, _ := printer.NewSubcommand(argmap.CommandParams{Name: "file"})
+	file.NewPositionalArg(argmap.PositionalArg{Name: "path", Required: true})
+
+	aMap, _ := parser.ParseFrom([]string{"--verbose", "print", "--encoding", "utf8", "file", "a.txt"})
+	expected := map[string]interface{}{
+		"verbose":         true,
+		"print.encoding":  []string{"utf8"},
+		"print.file.path": "a.txt",
+	}
+	if flat := argmap.GetAll(aMap); !reflect.DeepEqual(flat, expected) {
+		t.Errorf("Expected %v, got %v", expected, flat)
+	}
+
+	parser.SetExitOnHelp(false)
+	aMap, _ = parser.ParseFrom([]string{"print", "--help"})
+	if flat := argmap.GetAll(aMap); !reflect.DeepEqual(flat, map[string]interface{}{"help": true}) {
+		t.Errorf("Expected the trace to be dropped, got %v", flat)
+	}
+}
+
+func TestResponseFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "argmap")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	nested := filepath.Join(dir, "nested.txt")
+	main := filepath.Join(dir, "main.txt")
+	loop := filepath.Join(dir, "loop.txt")
+	ioutil.WriteFile(nested, []byte("--tags a b"), 0644)
+	ioutil.WriteFile(main, []byte("@"+nested+"\n--name \"jack smith\"\n"), 0644)
+	ioutil.WriteFile(loop, []byte("@"+loop), 0644)
+
+	parser := argmap.NewArgsParser("test", "")
+	parser.NewStringFlag(argmap.StringFlag{Name: "name"})
+	parser.NewListFlag(argmap.ListFlag{Name: "tags"})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "file"})
+	parser.SetResponseFilePrefix('@')
+
+	aMap, err := parser.ParseFrom([]string{"@" + main, "--", "@literal"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := map[string]interface{}{
+		"name": []string{"jack smith"},
+		"tags": []string{"a", "b"},
+		"file": "@literal",
+	}
+	if !reflect.DeepEqual(aMap, expected) {
+		t.Errorf("Expected %v, got %v", expected, aMap)
+	}
+
+	if _, err := parser.ParseFrom([]string{"@" + loop}); err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+		t.Errorf("Expected a nesting error, got %v", err)
+	}
+	missing := filepath.Join(dir, "missing.txt")
+	if _, err := parser.ParseFrom([]string{"@" + missing}); err == nil || !strings.Contains(err.Error(), missing) {
+		t.Errorf("Expected an error naming '%s', got %v", missing, err)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	parser := argmap.NewArgsParser("Explain test", "")
+	parser.NewStringFlag(argmap.StringFlag{Name: "output", Short: "o"})
+	parser.NewBoolFlag(argmap.BoolFlag{Short: "q"})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "file"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "run"})
+	cmd.NewIntFlag(argmap.IntFlag{Name: "jobs", Short: "j"})
+
+	tokens, err := parser.Explain([]string{"-qo", "out.txt", "run", "-j", "4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []argmap.TokenInterpretation{
+		{Token: "-q", Kind: argmap.TokenFlag, Key: "q", Command: []string{}},
+		{Token: "-o", Kind: argmap.TokenFlag, Key: "output", Command: []string{}},
+		{Token: "out.txt", Kind: argmap.TokenValue, Key: "output", Command: []string{}},
+		{Token: "run", Kind: argmap.TokenCommand, Key: "run", Command: []string{}},
+		{Token: "-j", Kind: argmap.TokenFlag, Key: "jobs", Command: []string{"run"}},
+		{Token: "4", Kind: argmap.TokenValue, Key: "jobs", Command: []string{"run"}},
+	}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Wrong interpretation: expected %v, got %v", expected, tokens)
+	}
+
+	// The tokens before the error are returned along with it
+	tokens, err = parser.Explain([]string{"a.txt", "b.txt"})
+	if _, ok := err.(argmap.UnrecognizedArgError); !ok {
+		t.Errorf("Expecting UnrecognizedArgError, got %v", err)
+	}
+	if len(tokens) != 2 || tokens[0].Kind != argmap.TokenPositional || tokens[0].Key != "file" || tokens[1].Kind != argmap.TokenUnrecognized {
+		t.Errorf("Wrong interpretation: %v", tokens)
+	}
+	if tokens[1].Kind.String() != "unrecognized" {
+		t.Errorf("Wrong kind name: %s", tokens[1].Kind)
+	}
+
+	// The help is not shown and the program doesn't exit
+	tokens, err = parser.Explain([]string{"--help"})
+	if err != nil || len(tokens) != 1 || tokens[0].Kind != argmap.TokenFlag {
+		t.Errorf("Wrong interpretation of the help flag: %v (%v)", tokens, err)
+	}
+}
+
 /**********************************************************************/
 /*** GENERIC FUNCTIONS TESTS ******************************************/
 /**********************************************************************/
@@ -566,3 +3198,423 @@ func TestCustomHelpFlagText(t *testing.T) {
 		t.Errorf("Wrong HelpFlag text: got %s", text)
 	}
 }
+
+func TestHelpGroups(t *testing.T) {
+	parser := argmap.NewArgsParser("prog", "groups")
+	parser.SetHelpWidth(-1)
+	parser.NewStringFlag(argmap.StringFlag{Name: "host", Group: "Networking", Help: "server host"})
+	parser.NewIntFlag(argmap.IntFlag{Name: "port", Group: "Networking", Help: "server port"})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "json", Group: "Output", Help: "prints JSON"})
+	parser.NewCountFlag(argmap.CountFlag{Short: "v", Help: "verbosity"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "run", Help: "runs"})
+	cmd.NewBoolFlag(argmap.BoolFlag{Name: "dry-run", Group: "Safety", Help: "does nothing"})
+
+	expected := "prog\ngroups\n\n" +
+		"Arguments:\n" +
+		"  -v             verbosity\n" +
+		"  -h, --help     shows help message and exits\n" +
+		"\nNetworking:\n" +
+		"  --host value   server host\n" +
+		"  --port int     server port\n" +
+		"\nOutput:\n" +
+		"  --json         prints JSON\n" +
+		"\nCommands:\n" +
+		"  run            runs\n" +
+		"Type -h or --help after a command for more details\n"
+	if help := parser.GenerateHelp(); help != expected {
+		t.Errorf("Wrong help message:\nexpected\n%s\ngot\n%s", expected, help)
+	}
+
+	expected = "    run   runs\n\n" +
+		"Arguments:\n" +
+		"    -h, --help  shows command help and exits\n" +
+		"\nSafety:\n" +
+		"    --dry-run   does nothing\n"
+	if help := cmd.GenerateHelp(); help != expected {
+		t.Errorf("Wrong command help:\nexpected\n%s\ngot\n%s", expected, help)
+	}
+}
+
+func TestHelpExamples(t *testing.T) {
+	parser := argmap.NewArgsParser("prog", "examples")
+	parser.SetHelpWidth(-1)
+	parser.NewDurationFlag(argmap.DurationFlag{Name: "timeout", Short: "t", Help: "request timeout", Example: "1m30s"})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "quiet", Help: "no output"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "tag", Help: "tags"})
+	cmd.NewListFlag(argmap.ListFlag{Short: "l", Help: "labels", Example: "env=prod tier=web"})
+
+	expected := "prog\nexamples\n\n" +
+		"Arguments:\n" +
+		"  -t, --timeout duration   request timeout\n" +
+		"                           e.g. --timeout 1m30s\n" +
+		"  --quiet                  no output\n" +
+		"  -h, --help               shows help message and exits\n" +
+		"\nCommands:\n" +
+		"  tag                      tags\n" +
+		"Type -h or --help after a command for more details\n"
+	if help := parser.GenerateHelp(); help != expected {
+		t.Errorf("Wrong help message:\nexpected\n%s\ngot\n%s", expected, help)
+	}
+
+	expected = "    tag   tags\n\n" +
+		"Arguments:\n" +
+		"    -l value value...   labels\n" +
+		"                        e.g. -l env=prod tier=web\n" +
+		"    -h, --help          shows command help and exits\n"
+	if help := cmd.GenerateHelp(); help != expected {
+		t.Errorf("Wrong command help:\nexpected\n%s\ngot\n%s", expected, help)
+	}
+}
+
+func TestCustomHelpFlagReps(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.SetExitOnHelp(false)
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "hidden", Short: "x"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "run"})
+
+	if err := parser.SetHelpFlagReps("x", ""); err == nil || err.Error() != "Error: representation '-x' already exists" {
+		t.Errorf("Expected a representation error, got %v", err)
+	}
+	if err := parser.SetHelpFlagReps("?", "usage"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	parser.SetHelpFlagMessage("shows the usage")
+	cmd.SetHelpFlagReps("?", "")
+
+	if _, err := parser.ParseFrom([]string{"-?"}); err != argmap.ErrHelpRequested {
+		t.Errorf("Expected ErrHelpRequested for '-?', got %v", err)
+	}
+	if _, err := parser.ParseFrom([]string{"run", "--help"}); err != argmap.ErrHelpRequested {
+		t.Errorf("Expected ErrHelpRequested for 'run --help', got %v", err)
+	}
+	if _, err := parser.ParseFrom([]string{"-h"}); err == nil {
+		t.Errorf("Expecting error for '-h', got nil")
+	}
+	if err := parser.NewBoolFlag(argmap.BoolFlag{Name: "help", Short: "h"}); err == nil {
+		t.Errorf("Expecting error for the 'help' identifier, got nil")
+	}
+	if help := parser.GenerateHelp(); !strings.Contains(help, "-?, --usage") || !strings.Contains(help, "shows the usage") {
+		t.Errorf("Expected the custom help flag in the help, got:\n%s", help)
+	} else if !strings.Contains(help, "Type -? or --help after a command for more details\n") {
+		t.Errorf("Expected the help flag of the commands in the hint, got:\n%s", help)
+	}
+	other, _ := parser.NewCommand(argmap.CommandParams{Name: "stop"})
+	if help := parser.GenerateHelp(); !strings.Contains(help, "Type the help flag after a command for more details\n") {
+		t.Errorf("Expected a generic hint for commands with different help flags, got:\n%s", help)
+	}
+	other.SetHelpFlagReps("?", "")
+	if help := parser.GenerateHelp(); !strings.Contains(help, "Type -? or --help after a command") {
+		t.Errorf("Expected the shared help flag in the hint, got:\n%s", help)
+	}
+
+	if err := parser.SetVersionFlagReps("v", ""); err == nil || err.Error() != "Error: the version flag is not set" {
+		t.Errorf("Expected an error without the version flag, got %v", err)
+	}
+	parser.SetVersion("1.2.3")
+	if err := parser.SetVersionFlagReps("x", ""); err == nil || err.Error() != "Error: representation '-x' already exists" {
+		t.Errorf("Expected a representation error, got %v", err)
+	}
+	if err := parser.SetVersionFlagReps("v", "ver"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, err := parser.ParseFrom([]string{"--ver"}); err != argmap.ErrVersionRequested {
+		t.Errorf("Expected ErrVersionRequested for '--ver', got %v", err)
+	}
+	if _, err := parser.ParseFrom([]string{"-V"}); err == nil {
+		t.Errorf("Expecting error for '-V', got nil")
+	}
+	if help := parser.GenerateHelp(); !strings.Contains(help, "-v, --ver") {
+		t.Errorf("Expected the custom version flag in the help, got:\n%s", help)
+	}
+
+	parser.DisableHelpFlag()
+	if err := parser.SetHelpFlagReps("?", ""); err == nil {
+		t.Errorf("Expecting error with the help flag disabled, got nil")
+	}
+}
+
+func TestCommandNames(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose"})
+	parser.NewCommand(argmap.CommandParams{Name: "run"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "print"})
+	cmd.NewSubcommand(argmap.CommandParams{Name: "string"})
+	cmd.NewSubcommand(argmap.CommandParams{Name: "file"})
+
+	if names := parser.CommandNames(); !reflect.DeepEqual(names, []string{"print", "run"}) {
+		t.Errorf("Wrong command names: got %s", names)
+	}
+	if names := cmd.SubcommandNames(); !reflect.DeepEqual(names, []string{"file", "string"}) {
+		t.Errorf("Wrong subcommand names: got %s", names)
+	}
+}
+
+func TestGetCommand(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "print-all"})
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "remove", Aliases: []string{"rm"}})
+	sub, _ := cmd.NewSubcommand(argmap.CommandParams{Name: "file"})
+
+	if found, ok := parser.GetCommand("remove"); !ok || found != cmd {
+		t.Errorf("Expected the 'remove' command, got %v", found)
+	}
+	if found, ok := parser.GetCommand("rm"); !ok || found != cmd {
+		t.Errorf("Expected the 'remove' command for its alias, got %v", found)
+	}
+	if found, ok := cmd.GetSubcommand("file"); !ok || found != sub {
+		t.Errorf("Expected the 'file' subcommand, got %v", found)
+	}
+	for _, name := range []string{"print-all", "file", "missing"} {
+		if found, ok := parser.GetCommand(name); ok || found != nil {
+			t.Errorf("Expected no command for '%s', got %v", name, found)
+		}
+	}
+	if _, ok := cmd.GetSubcommand("remove"); ok {
+		t.Errorf("Expected no 'remove' subcommand")
+	}
+}
+
+func TestGetCommandMap_Nil(t *testing.T) {
+	var nilMap map[string]interface{}
+	for _, aMap := range []map[string]interface{}{{"run": nil}, {"run": nilMap}} {
+		cmd, cmdMap, err := argmap.GetCommandMap(aMap)
+		if err != nil {
+			t.Error(err)
+		} else if cmd != "run" || cmdMap == nil {
+			t.Errorf("Wrong command map: got %s, %v", cmd, cmdMap)
+		}
+	}
+}
+
+func TestCustomTraceFormatter(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "print"})
+	sub, _ := cmd.NewSubcommand(argmap.CommandParams{Name: "file"})
+	trace := []*argmap.Command{sub, cmd}
+
+	if help := parser.GenerateCommandHelp(trace); !strings.Contains(help, "Reference:  print file\n") {
+		t.Errorf("Wrong default reference line: got %s", help)
+	}
+
+	parser.SetTraceFormatter(func(cmdTrace []*argmap.Command) string {
+		names := []string{ProjectName}
+		for i := len(cmdTrace) - 1; i >= 0; i-- {
+			names = append(names, cmdTrace[i].GetID())
+		}
+		return strings.Join(names, " > ")
+	})
+	if help := parser.GenerateCommandHelp(trace); !strings.Contains(help, "Reference: argmap > print > file\n") {
+		t.Errorf("Wrong custom reference line: got %s", help)
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "size", NArgs: 2})
+	parser.NewStringFlag(argmap.StringFlag{Name: "unused"})
+	parser.NewListFlag(argmap.ListFlag{Name: "list"})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose"})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "quiet"})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "input"})
+	parser.NewCommand(argmap.CommandParams{Name: "run"})
+
+	os.Args = []string{ProjectName, "in.txt", "--size", "3", "4", "--list", "a", "b", "c", "--verbose", "run"}
+	aMap, err := parser.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expConfig := map[string]string{"size": "3,4", "list": "a,b,c", "verbose": "true", "quiet": "false", "input": "in.txt"}
+	if config := parser.EffectiveConfig(aMap); !reflect.DeepEqual(config, expConfig) {
+		t.Errorf("Wrong effective config: expected %v, got %v", expConfig, config)
+	}
+}
+
+func TestValidateSpec(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "size", NArgs: 2, Vars: []string{"n", "n"}})
+	if errs := parser.ValidateSpec(); len(errs) != 1 {
+		t.Errorf("Expecting 1 error, got %v", errs)
+	}
+
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "run"})
+	cmd.NewPositionalArg(argmap.PositionalArg{Name: "opt"})
+	cmd.NewPositionalArg(argmap.PositionalArg{Name: "req", Required: true})
+	errs := parser.ValidateSpec()
+	if len(errs) != 2 {
+		t.Errorf("Expecting 2 errors, got %v", errs)
+	} else if !strings.Contains(errs[1].Error(), "in command 'run'") {
+		t.Errorf("Wrong error message: got %s", errs[1])
+	}
+
+	// Sorting the arguments (e.g. to generate the help) doesn't hide the mistake
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "opt"})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "req", Required: true})
+	parser.GenerateHelp()
+	if errs := parser.ValidateSpec(); len(errs) != 3 {
+		t.Errorf("Expecting 3 errors, got %v", errs)
+	}
+
+	// Each parser numbers its own positionals, so a clone keeps the order of the original
+	clone := parser.Clone()
+	clone.NewPositionalArg(argmap.PositionalArg{Name: "last", Required: true})
+	if errs := clone.ValidateSpec(); len(errs) != 4 {
+		t.Errorf("Expecting 4 errors, got %v", errs)
+	}
+
+	parser = argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "size", NArgs: 3, Vars: []string{"width", "height"}})
+	parser.NewStringFlag(argmap.StringFlag{Name: "point", NArgs: 2})
+	errs = parser.ValidateSpec()
+	if len(errs) != 1 || errs[0].Error() != "Error: flag 'size' takes 3 values but names 2" {
+		t.Errorf("Expecting a value names mismatch, got %v", errs)
+	}
+}
+
+func TestHelpTemplates(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, "a test program")
+	parser.SetHelpHeaderTemplate("{{.Name}}: {{.Description}}")
+	parser.SetHelpFooterTemplate("Report bugs about {{.Name}}")
+
+	help := parser.GenerateHelp()
+	if !strings.HasPrefix(help, ProjectName+": a test program\n") {
+		t.Errorf("Wrong help header: got %s", help)
+	} else if !strings.HasSuffix(help, "\nReport bugs about "+ProjectName+"\n") {
+		t.Errorf("Wrong help footer: got %s", help)
+	}
+}
+
+func TestGetSubMap(t *testing.T) {
+	var nilMap map[string]interface{}
+	aMap := map[string]interface{}{"run": nil, "add": nilMap, "print": map[string]interface{}{"v": true}, "hello": []string{"x"}}
+
+	for _, cmd := range []string{"run", "add", "print"} {
+		if cmdMap, ok := argmap.GetSubMap(aMap, cmd); !ok || cmdMap == nil {
+			t.Errorf("Wrong submap for '%s': got %v, %t", cmd, cmdMap, ok)
+		}
+	}
+	for _, key := range []string{"hello", "missing"} {
+		if _, ok := argmap.GetSubMap(aMap, key); ok {
+			t.Errorf("Unexpected submap for '%s'", key)
+		}
+	}
+}
+
+func TestGenerateHelpDepth(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	cmd, _ := parser.NewCommand(argmap.CommandParams{Name: "print", Help: "prints something"})
+	sub, _ := cmd.NewSubcommand(argmap.CommandParams{Name: "file", Help: "prints a file"})
+	sub.NewSubcommand(argmap.CommandParams{Name: "fast", Help: "prints a file quickly"})
+
+	help := parser.GenerateHelpDepth(2)
+	if !strings.Contains(help, "Command: print\n") || !strings.Contains(help, "Command: print file\n") {
+		t.Errorf("Missing command help: got %s", help)
+	} else if strings.Contains(help, "Command: print file fast\n") {
+		t.Errorf("Unexpected command help beyond depth: got %s", help)
+	} else if !strings.Contains(help, "(subcommands of 'print file' not shown)") {
+		t.Errorf("Missing depth note: got %s", help)
+	}
+
+	if help := parser.GenerateHelpDepth(3); !strings.Contains(help, "Command: print file fast\n") {
+		t.Errorf("Missing command help: got %s", help)
+	}
+}
+
+func TestArity(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewStringFlag(argmap.StringFlag{Name: "size", NArgs: 2})
+	parser.NewListFlag(argmap.ListFlag{Name: "list"})
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose"})
+	parser.NewPositionalArg(argmap.PositionalArg{Name: "input"})
+
+	expArity := map[string]int{"help": 0, "size": 2, "list": -1, "verbose": 0, "input": 1}
+	for _, a := range parser.GetArgsList() {
+		if a.Arity() != expArity[a.GetID()] {
+			t.Errorf("Wrong arity for '%s': expected %d, got %d", a.GetID(), expArity[a.GetID()], a.Arity())
+		}
+	}
+}
+
+func TestGetOrderedPairs(t *testing.T) {
+	aMap := map[string]interface{}{"env": []string{"A=1", "B=x=y", "A=2"}, "header": []string{"Accept:text/html"}, "bad": []string{"A"}}
+
+	expPairs := [][2]string{{"A", "1"}, {"B", "x=y"}, {"A", "2"}}
+	if pairs, err := argmap.GetOrderedPairs(aMap, "env"); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(pairs, expPairs) {
+		t.Errorf("Wrong pairs: expected %v, got %v", expPairs, pairs)
+	}
+
+	if pairs, err := argmap.GetOrderedPairsSep(aMap, "header", ":"); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(pairs, [][2]string{{"Accept", "text/html"}}) {
+		t.Errorf("Wrong pairs: got %v", pairs)
+	}
+
+	if _, err := argmap.GetOrderedPairs(aMap, "bad"); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+}
+
+func TestExamplesPosition(t *testing.T) {
+	parser := argmap.NewArgsParser(ProjectName, t.Name())
+	parser.NewBoolFlag(argmap.BoolFlag{Name: "verbose"})
+	parser.AddExample(ProjectName + " --verbose")
+
+	help := parser.GenerateHelp()
+	if !strings.HasSuffix(help, "\nExamples:\n  "+ProjectName+" --verbose\n") {
+		t.Errorf("Wrong examples position: got %s", help)
+	}
+
+	parser.SetExamplesPosition(argmap.ExamplesTop)
+	help = parser.GenerateHelp()
+	if !strings.HasPrefix(help, ProjectName+"\n"+t.Name()+"\n\nExamples:\n") {
+		t.Errorf("Wrong examples position: got %s", help)
+	}
+}
+
+func TestGetNumericSlices(t *testing.T) {
+	aMap := map[string]interface{}{"ports": []string{"80", "443", "8080"}, "ratios": []string{"0.5", "2"}, "bad": []string{"1", "x"}}
+
+	if ports, err := argmap.GetIntSlice(aMap, "ports"); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(ports, []int{80, 443, 8080}) {
+		t.Errorf("Wrong int slice: got %v", ports)
+	}
+	if ratios, err := argmap.GetFloatSlice(aMap, "ratios"); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(ratios, []float64{0.5, 2}) {
+		t.Errorf("Wrong float slice: got %v", ratios)
+	}
+
+	if _, err := argmap.GetIntSlice(aMap, "bad"); err == nil || !strings.Contains(err.Error(), "'x'") {
+		t.Errorf("Expecting error naming 'x', got %v", err)
+	}
+	if _, err := argmap.GetFloatSlice(aMap, "missing"); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+}
+
+func TestGetList(t *testing.T) {
+	aMap := map[string]interface{}{"list": []string{"a", "b"}, "hello": []string{"jack"}, "v": true}
+
+	if list, err := argmap.GetList(aMap, "list"); err != nil || !reflect.DeepEqual(list, []string{"a", "b"}) {
+		t.Errorf("Wrong list: got %v, %v", list, err)
+	}
+	if value, err := argmap.GetListValue(aMap, "hello", 0); err != nil || value != "jack" {
+		t.Errorf("Wrong value: got %s, %v", value, err)
+	}
+
+	if _, err := argmap.GetList(aMap, "v"); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+	if _, err := argmap.GetList(aMap, "missing"); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+	if _, err := argmap.GetListValue(aMap, "list", 2); err == nil {
+		t.Errorf("Expecting error, got nil")
+	}
+}
@@ -1,37 +1,85 @@
 package argmap
 
 import (
+	"context"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Argument interface defines the basic methods an argument struct must have
 //  GetID()             returns the identifier of the argument to be used in the map
 //  GetHelpStrings()    returns the two sides of the help message (see declarations for details)
 //  Represent()         eventual representations of the flag in the user inputs (e.g.: "-h", "--help")
+//  Arity()             number of values consumed by the argument (-1 if variable)
 type Argument interface {
 	GetID() string
 	GetHelpStrings() []string
 	Represent() []string
+	Arity() int
 	getOrder() int
 }
 
 const orderPositionalReq = 1
 const orderPositionalOpt = 2
 const orderStringFlag = 3
-const orderListFlag = 4
-const orderBoolFlag = 5
-const orderHelpFlag = 9
-const orderCommand = 10
+const orderChoiceFlag = 4
+const orderIntFlag = 5
+const orderFloatFlag = 6
+const orderDurationFlag = 7
+const orderListFlag = 8
+const orderBoolFlag = 9
+const orderCountFlag = 10
+const orderHelpFlag = 11
+const orderVersionFlag = 12
+const orderCommand = 13
+
+// Marks the help message of required flags
+func requiredHelp(help string, required bool) string {
+	if !required {
+		return help
+	}
+	if help == "" {
+		return "(required)"
+	}
+	return help + " (required)"
+}
 
 /************************************************************/
 
 // StringFlag argument
+//  CommaSplit    also accepts the NArgs values joined by commas in a single token (e.g. "--coords 3,4")
+//  Transform     optional function normalizing each value before it's stored (e.g. strings.ToLower)
+//  Required      parsing fails if the flag is not inserted by the user
+//  Env           environment variable read if the flag is not inserted (values separated by spaces)
+//  Validate      optional function checking each value after Transform: an error aborts the parsing
+//  ValidateContext  like Validate, but also gets the context of the parsing (see ParseContext)
+//  AllowDashValue  takes the next NArgs tokens as values even if they look like flags (e.g. "--pattern -v")
+//  Variadic      consumes all the following tokens up to the next flag or "--", even none (NArgs is ignored)
+//  Hidden        parsed as usual but not shown in the help messages
+//  Deprecated    if not empty, the flag still works but a warning with this message is collected when inserted
+//  Group         name of the help section listing the flag (ungrouped flags go under "Arguments")
+//  Example       example values shown beneath the flag in the help as "e.g. --flag <Example>"
 type StringFlag struct {
-	Name  string
-	Short string
-	NArgs int
-	Vars  []string
-	Help  string
+	Name            string
+	Short           string
+	NArgs           int
+	Vars            []string
+	Help            string
+	CommaSplit      bool
+	Transform       func(string) string
+	Required        bool
+	Env             string
+	Validate        func(string) error
+	ValidateContext func(context.Context, string) error
+	AllowDashValue  bool
+	Variadic        bool
+	Hidden          bool
+	Deprecated      string
+	Group           string
+	Example         string
+	named           int
 }
 
 // GetID returns the identifier of the argument
@@ -70,6 +118,9 @@ func (f StringFlag) GetHelpStrings() []string {
 	for _, s := range f.Vars {
 		metaVars += fmt.Sprintf("%s ", s)
 	}
+	if f.Variadic {
+		metaVars += "... "
+	}
 
 	var repr string
 	if f.Name != "" && f.Short != "" {
@@ -81,7 +132,61 @@ func (f StringFlag) GetHelpStrings() []string {
 	}
 
 	leftHand := fmt.Sprintf("%s %s", repr, metaVars)
-	return []string{leftHand, f.Help}
+	return []string{leftHand, requiredHelp(f.Help, f.Required)}
+}
+
+// Applies the Transform function (if any) to each value
+func (f StringFlag) transform(values []string) []string {
+	if f.Transform != nil {
+		for i, v := range values {
+			values[i] = f.Transform(v)
+		}
+	}
+	return values
+}
+
+// Applies the Validate and ValidateContext functions (if any) to each value. With more values,
+// the error refers to the wrong one with its name in Vars (e.g. "--size height"), if the name
+// is unique
+func (f StringFlag) validate(ctx context.Context, values []string, name string) error {
+	if f.Validate != nil || f.ValidateContext != nil {
+		for i, v := range values {
+			var err error
+			if f.Validate != nil {
+				err = f.Validate(v)
+			}
+			if err == nil && f.ValidateContext != nil {
+				if err = ctx.Err(); err != nil {
+					return err
+				}
+				err = f.ValidateContext(ctx, v)
+			}
+			if err != nil {
+				if v := varName(f.Vars, i); v != "" {
+					return fmt.Errorf("Error: value for '%s %s' is invalid: %s", name, v, err)
+				}
+				return fmt.Errorf("Error: invalid value for '%s': %s", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// varName returns the name in Vars of the i-th value of a flag taking more values, if the name
+// is unique (empty otherwise)
+func varName(vars []string, i int) string {
+	if len(vars) > 1 && i < len(vars) && !contains(vars[:i], vars[i]) && !contains(vars[i+1:], vars[i]) {
+		return vars[i]
+	}
+	return ""
+}
+
+// Arity returns the number of values consumed by the flag (-1 if variadic)
+func (f StringFlag) Arity() int {
+	if f.Variadic {
+		return -1
+	}
+	return f.NArgs
 }
 
 // Defines the priority of the argument for sorting (also used to determine the argument type)
@@ -91,12 +196,337 @@ func (f StringFlag) getOrder() int {
 
 /*******************************************************/
 
+// ChoiceFlag argument, accepting a single value among a fixed set of choices.
+// The value is stored in the map like a StringFlag with one value
+//  Hidden      parsed as usual but not shown in the help messages
+//  Deprecated  if not empty, the flag still works but a warning with this message is collected when inserted
+//  Group       name of the help section listing the flag (ungrouped flags go under "Arguments")
+//  Example     example values shown beneath the flag in the help as "e.g. --flag <Example>"
+type ChoiceFlag struct {
+	Name       string
+	Short      string
+	Choices    []string
+	Help       string
+	Hidden     bool
+	Deprecated string
+	Group      string
+	Example    string
+}
+
+// GetID returns the identifier of the argument
+func (f ChoiceFlag) GetID() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return f.Short
+}
+
+// ShortArg returns short flag
+func (f ChoiceFlag) ShortArg() string {
+	return "-" + f.Short
+}
+
+// LongArg returns full name flag
+func (f ChoiceFlag) LongArg() string {
+	return "--" + f.Name
+}
+
+// Represent returns possible argument representations
+func (f ChoiceFlag) Represent() []string {
+	if f.Name != "" && f.Short != "" {
+		return []string{f.ShortArg(), f.LongArg()}
+	} else if f.Name != "" {
+		return []string{f.LongArg()}
+	} else {
+		return []string{f.ShortArg()}
+	}
+}
+
+// GetHelpStrings returns the two hand sides of the help message
+//  Example:  ["-l, --log-level {debug,info,warn}", "this is an example of help message"]
+func (f ChoiceFlag) GetHelpStrings() []string {
+	var repr string
+	if f.Name != "" && f.Short != "" {
+		repr = fmt.Sprintf("%s, %s", f.ShortArg(), f.LongArg())
+	} else if f.Name == "" {
+		repr = f.ShortArg()
+	} else {
+		repr = f.LongArg()
+	}
+
+	leftHand := fmt.Sprintf("%s {%s} ", repr, strings.Join(f.Choices, ","))
+	return []string{leftHand, f.Help}
+}
+
+// Arity returns 1 since the flag consumes a single value
+func (f ChoiceFlag) Arity() int {
+	return 1
+}
+
+// Defines the priority of the argument for sorting (also used to determine the argument type)
+func (f ChoiceFlag) getOrder() int {
+	return orderChoiceFlag
+}
+
+/*******************************************************/
+
+// IntFlag argument, storing the values in the map as a slice of integers
+//  Default    values stored in the map if the flag is not inserted (must be NArgs values)
+//  Env        environment variable read if the flag is not inserted, taking precedence over Default
+//  Hidden     parsed as usual but not shown in the help messages
+//  Deprecated if not empty, the flag still works but a warning with this message is collected when inserted
+//  Group      name of the help section listing the flag (ungrouped flags go under "Arguments")
+//  Example    example values shown beneath the flag in the help as "e.g. --flag <Example>"
+type IntFlag struct {
+	Name       string
+	Short      string
+	NArgs      int
+	Help       string
+	Default    []int
+	Env        string
+	Hidden     bool
+	Deprecated string
+	Group      string
+	Example    string
+}
+
+// GetID returns the identifier of the argument
+func (f IntFlag) GetID() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return f.Short
+}
+
+// ShortArg returns short flag
+func (f IntFlag) ShortArg() string {
+	return "-" + f.Short
+}
+
+// LongArg returns full name flag
+func (f IntFlag) LongArg() string {
+	return "--" + f.Name
+}
+
+// Represent returns possible argument representations
+func (f IntFlag) Represent() []string {
+	if f.Name != "" && f.Short != "" {
+		return []string{f.ShortArg(), f.LongArg()}
+	} else if f.Name != "" {
+		return []string{f.LongArg()}
+	} else {
+		return []string{f.ShortArg()}
+	}
+}
+
+// GetHelpStrings returns the two hand sides of the help message
+//  Example:  ["-c, --count int int", "this is an example of help message"]
+func (f IntFlag) GetHelpStrings() []string {
+	var repr string
+	if f.Name != "" && f.Short != "" {
+		repr = fmt.Sprintf("%s, %s", f.ShortArg(), f.LongArg())
+	} else if f.Name == "" {
+		repr = f.ShortArg()
+	} else {
+		repr = f.LongArg()
+	}
+
+	leftHand := fmt.Sprintf("%s %s", repr, strings.Repeat("int ", f.NArgs))
+	return []string{leftHand, f.Help}
+}
+
+// Arity returns the number of values consumed by the flag
+func (f IntFlag) Arity() int {
+	return f.NArgs
+}
+
+// Defines the priority of the argument for sorting (also used to determine the argument type)
+func (f IntFlag) getOrder() int {
+	return orderIntFlag
+}
+
+/*******************************************************/
+
+// FloatFlag argument, storing the values in the map as a slice of floats
+//  Default    values stored in the map if the flag is not inserted (must be NArgs values)
+//  Hidden     parsed as usual but not shown in the help messages
+//  Deprecated if not empty, the flag still works but a warning with this message is collected when inserted
+//  Group      name of the help section listing the flag (ungrouped flags go under "Arguments")
+//  Example    example values shown beneath the flag in the help as "e.g. --flag <Example>"
+type FloatFlag struct {
+	Name       string
+	Short      string
+	NArgs      int
+	Help       string
+	Default    []float64
+	Hidden     bool
+	Deprecated string
+	Group      string
+	Example    string
+}
+
+// GetID returns the identifier of the argument
+func (f FloatFlag) GetID() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return f.Short
+}
+
+// ShortArg returns short flag
+func (f FloatFlag) ShortArg() string {
+	return "-" + f.Short
+}
+
+// LongArg returns full name flag
+func (f FloatFlag) LongArg() string {
+	return "--" + f.Name
+}
+
+// Represent returns possible argument representations
+func (f FloatFlag) Represent() []string {
+	if f.Name != "" && f.Short != "" {
+		return []string{f.ShortArg(), f.LongArg()}
+	} else if f.Name != "" {
+		return []string{f.LongArg()}
+	} else {
+		return []string{f.ShortArg()}
+	}
+}
+
+// GetHelpStrings returns the two hand sides of the help message
+//  Example:  ["-r, --ratio float float", "this is an example of help message"]
+func (f FloatFlag) GetHelpStrings() []string {
+	var repr string
+	if f.Name != "" && f.Short != "" {
+		repr = fmt.Sprintf("%s, %s", f.ShortArg(), f.LongArg())
+	} else if f.Name == "" {
+		repr = f.ShortArg()
+	} else {
+		repr = f.LongArg()
+	}
+
+	leftHand := fmt.Sprintf("%s %s", repr, strings.Repeat("float ", f.NArgs))
+	return []string{leftHand, f.Help}
+}
+
+// Arity returns the number of values consumed by the flag
+func (f FloatFlag) Arity() int {
+	return f.NArgs
+}
+
+// Defines the priority of the argument for sorting (also used to determine the argument type)
+func (f FloatFlag) getOrder() int {
+	return orderFloatFlag
+}
+
+/*******************************************************/
+
+// DurationFlag argument, storing the values in the map as a slice of time.Duration (e.g. "30s")
+//  Default    values stored in the map if the flag is not inserted (must be NArgs values)
+//  Hidden     parsed as usual but not shown in the help messages
+//  Deprecated if not empty, the flag still works but a warning with this message is collected when inserted
+//  Group      name of the help section listing the flag (ungrouped flags go under "Arguments")
+//  Example    example values shown beneath the flag in the help as "e.g. --flag <Example>"
+type DurationFlag struct {
+	Name       string
+	Short      string
+	NArgs      int
+	Help       string
+	Default    []time.Duration
+	Hidden     bool
+	Deprecated string
+	Group      string
+	Example    string
+}
+
+// GetID returns the identifier of the argument
+func (f DurationFlag) GetID() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return f.Short
+}
+
+// ShortArg returns short flag
+func (f DurationFlag) ShortArg() string {
+	return "-" + f.Short
+}
+
+// LongArg returns full name flag
+func (f DurationFlag) LongArg() string {
+	return "--" + f.Name
+}
+
+// Represent returns possible argument representations
+func (f DurationFlag) Represent() []string {
+	if f.Name != "" && f.Short != "" {
+		return []string{f.ShortArg(), f.LongArg()}
+	} else if f.Name != "" {
+		return []string{f.LongArg()}
+	} else {
+		return []string{f.ShortArg()}
+	}
+}
+
+// GetHelpStrings returns the two hand sides of the help message
+//  Example:  ["-t, --timeout duration", "this is an example of help message"]
+func (f DurationFlag) GetHelpStrings() []string {
+	var repr string
+	if f.Name != "" && f.Short != "" {
+		repr = fmt.Sprintf("%s, %s", f.ShortArg(), f.LongArg())
+	} else if f.Name == "" {
+		repr = f.ShortArg()
+	} else {
+		repr = f.LongArg()
+	}
+
+	leftHand := fmt.Sprintf("%s %s", repr, strings.Repeat("duration ", f.NArgs))
+	return []string{leftHand, f.Help}
+}
+
+// Arity returns the number of values consumed by the flag
+func (f DurationFlag) Arity() int {
+	return f.NArgs
+}
+
+// Defines the priority of the argument for sorting (also used to determine the argument type)
+func (f DurationFlag) getOrder() int {
+	return orderDurationFlag
+}
+
+/*******************************************************/
+
+// Predefined formats for the values of a ListFlag in the help message
+//  ListHelpRepeat      item item...  (default)
+//  ListHelpOptional    item [item ...]
+//  ListHelpEllipsis    item...
+const (
+	ListHelpRepeat   = "%[1]s %[1]s..."
+	ListHelpOptional = "%[1]s [%[1]s ...]"
+	ListHelpEllipsis = "%[1]s..."
+)
+
 // ListFlag argument
+//  HelpFormat    format of the values in the help message, having Var as its only operand (see ListHelpRepeat)
+//  Required      parsing fails if the flag is not inserted by the user
+//  Max           maximum number of values consumed (0 means no limit): the following ones are left to the positionals
+//  Hidden        parsed as usual but not shown in the help messages
+//  Deprecated    if not empty, the flag still works but a warning with this message is collected when inserted
+//  Group         name of the help section listing the flag (ungrouped flags go under "Arguments")
+//  Example       example values shown beneath the flag in the help as "e.g. --flag <Example>"
 type ListFlag struct {
-	Name  string
-	Short string
-	Var   string
-	Help  string
+	Name       string
+	Short      string
+	Var        string
+	Help       string
+	HelpFormat string
+	Required   bool
+	Max        int
+	Hidden     bool
+	Deprecated string
+	Group      string
+	Example    string
 }
 
 // GetID returns the identifier of the argument
@@ -140,8 +570,18 @@ func (f ListFlag) GetHelpStrings() []string {
 		repr = f.LongArg()
 	}
 
-	leftHand := fmt.Sprintf("%s %s %s... ", repr, f.Var, f.Var)
-	return []string{leftHand, f.Help}
+	format := f.HelpFormat
+	if format == "" {
+		format = ListHelpRepeat
+	}
+
+	leftHand := fmt.Sprintf("%s %s ", repr, fmt.Sprintf(format, f.Var))
+	return []string{leftHand, requiredHelp(f.Help, f.Required)}
+}
+
+// Arity returns -1 since the flag consumes a variable number of values
+func (f ListFlag) Arity() int {
+	return -1
 }
 
 // Defines the priority of the argument for sorting (also used to determine the argument type)
@@ -152,10 +592,22 @@ func (f ListFlag) getOrder() int {
 /************************************************************/
 
 // BoolFlag argument
+//  Required    parsing fails if the flag is not inserted by the user
+//  Hidden      parsed as usual but not shown in the help messages
+//  Deprecated  if not empty, the flag still works but a warning with this message is collected when inserted
+//  Negatable   also accepts "--no-<Name>", which stores false (requires a Name)
+//  Default     value stored in the map if the flag is not inserted (set true for features to be turned off)
+//  Group       name of the help section listing the flag (ungrouped flags go under "Arguments")
 type BoolFlag struct {
-	Name  string
-	Short string
-	Help  string
+	Name       string
+	Short      string
+	Help       string
+	Required   bool
+	Hidden     bool
+	Deprecated string
+	Negatable  bool
+	Default    bool
+	Group      string
 }
 
 // GetID returns the identifier of the argument
@@ -176,8 +628,80 @@ func (f BoolFlag) LongArg() string {
 	return "--" + f.Name
 }
 
-// Represent returns possible argument representations
+// NegatedArg returns the negated full name flag
+func (f BoolFlag) NegatedArg() string {
+	return "--no-" + f.Name
+}
+
+// Represent returns possible argument representations, followed by the negated one if the
+// flag is Negatable
 func (f BoolFlag) Represent() []string {
+	var reprs []string
+	if f.Name != "" && f.Short != "" {
+		reprs = []string{f.ShortArg(), f.LongArg()}
+	} else if f.Name != "" {
+		reprs = []string{f.LongArg()}
+	} else {
+		return []string{f.ShortArg()}
+	}
+
+	if f.Negatable {
+		reprs = append(reprs, f.NegatedArg())
+	}
+	return reprs
+}
+
+// GetHelpStrings returns the two hand sides of the help message
+//  Example:  ["-b, --bool, --no-bool", "this is an example of help message"]
+func (f BoolFlag) GetHelpStrings() []string {
+	return []string{strings.Join(f.Represent(), ", "), requiredHelp(f.Help, f.Required)}
+}
+
+// Arity returns 0 since the flag doesn't consume any value
+func (f BoolFlag) Arity() int {
+	return 0
+}
+
+// Defines the priority of the argument for sorting (also used to determine the argument type)
+func (f BoolFlag) getOrder() int {
+	return orderBoolFlag
+}
+
+/************************************************************/
+
+// CountFlag argument, counting how many times it is inserted (e.g. "-vvv" is stored as 3)
+//  Hidden      parsed as usual but not shown in the help messages
+//  Deprecated  if not empty, the flag still works but a warning with this message is collected when inserted
+//  Group       name of the help section listing the flag (ungrouped flags go under "Arguments")
+type CountFlag struct {
+	Name       string
+	Short      string
+	Help       string
+	Hidden     bool
+	Deprecated string
+	Group      string
+}
+
+// GetID returns the identifier of the argument
+func (f CountFlag) GetID() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return f.Short
+}
+
+// ShortArg returns short flag
+func (f CountFlag) ShortArg() string {
+	return "-" + f.Short
+}
+
+// LongArg returns full name flag
+func (f CountFlag) LongArg() string {
+	return "--" + f.Name
+}
+
+// Represent returns possible argument representations
+func (f CountFlag) Represent() []string {
 	if f.Name != "" && f.Short != "" {
 		return []string{f.ShortArg(), f.LongArg()}
 	} else if f.Name != "" {
@@ -188,8 +712,8 @@ func (f BoolFlag) Represent() []string {
 }
 
 // GetHelpStrings returns the two hand sides of the help message
-//  Example:  ["-b, --bool", "this is an example of help message"]
-func (f BoolFlag) GetHelpStrings() []string {
+//  Example:  ["-v, --verbose", "this is an example of help message"]
+func (f CountFlag) GetHelpStrings() []string {
 	var leftHand string
 	if f.Name != "" && f.Short != "" {
 		leftHand = fmt.Sprintf("%s, %s", f.ShortArg(), f.LongArg())
@@ -202,18 +726,46 @@ func (f BoolFlag) GetHelpStrings() []string {
 	return []string{leftHand, f.Help}
 }
 
+// Arity returns 0 since the flag doesn't consume any value
+func (f CountFlag) Arity() int {
+	return 0
+}
+
 // Defines the priority of the argument for sorting (also used to determine the argument type)
-func (f BoolFlag) getOrder() int {
-	return orderBoolFlag
+func (f CountFlag) getOrder() int {
+	return orderCountFlag
 }
 
 /************************************************************/
 
+// Kind of the value expected by a PositionalArg
+type Kind int
+
+// Possible kinds of positional values, stored in the map respectively as
+// string, int, float64 and bool
+const (
+	KindString Kind = iota
+	KindInt
+	KindFloat
+	KindBool
+)
+
 // PositionalArg argument
+//  Literal           the positional takes its token even if it matches a flag (except for the help flag)
+//  Kind              type of the value to be stored in the map (default is KindString)
+//  Transform         optional function normalizing the value before its conversion (e.g. strings.TrimSpace)
+//  FileCompletion    hint for the shell completion scripts to offer file paths for the positional
+//  Variadic          the positional collects all the remaining values in a slice of strings (must be the last one)
 type PositionalArg struct {
-	Name     string
-	Help     string
-	Required bool
+	Name           string
+	Help           string
+	Required       bool
+	Literal        bool
+	Kind           Kind
+	Transform      func(string) string
+	FileCompletion bool
+	Variadic       bool
+	inserted       uint64
 }
 
 // GetID returns the identifier of the argument
@@ -222,12 +774,16 @@ func (a PositionalArg) GetID() string {
 }
 
 // MetaArg returns a representation of the argument
-//  Example:  required [optional]
+//  Example:  required [optional] [variadic...]
 func (a PositionalArg) MetaArg() string {
+	name := a.Name
+	if a.Variadic {
+		name += "..."
+	}
 	if a.Required {
-		return a.Name
+		return name
 	}
-	return fmt.Sprintf("[%s]", a.Name)
+	return fmt.Sprintf("[%s]", name)
 }
 
 // Represent returns no representations
@@ -243,6 +799,40 @@ func (a PositionalArg) GetHelpStrings() []string {
 	return []string{a.MetaArg(), a.Help}
 }
 
+// Converts the inserted value according to the Kind of the positional
+func (a PositionalArg) convert(s string) (interface{}, error) {
+	if a.Transform != nil {
+		s = a.Transform(s)
+	}
+
+	switch a.Kind {
+	case KindInt:
+		if v, err := strconv.Atoi(s); err == nil {
+			return v, nil
+		}
+		return nil, fmt.Errorf("Error: value '%s' for positional argument '%s' is not an integer", s, a.Name)
+	case KindFloat:
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			return v, nil
+		}
+		return nil, fmt.Errorf("Error: value '%s' for positional argument '%s' is not a number", s, a.Name)
+	case KindBool:
+		if v, err := parseBoolString(s); err == nil {
+			return v, nil
+		}
+		return nil, fmt.Errorf("Error: value '%s' for positional argument '%s' is not a boolean", s, a.Name)
+	}
+	return s, nil
+}
+
+// Arity returns 1 for a single value, -1 if the positional is variadic
+func (a PositionalArg) Arity() int {
+	if a.Variadic {
+		return -1
+	}
+	return 1
+}
+
 // Defines the priority of the argument for sorting (also used to determine the argument type)
 func (a PositionalArg) getOrder() int {
 	if a.Required {
@@ -253,9 +843,13 @@ func (a PositionalArg) getOrder() int {
 
 /************************************************************/
 
-// HelpFlag argument
+// HelpFlag argument, stored in the map as "help" whatever its representations
+//  Short    short name replacing "h" (e.g. "?")
+//  Long     full name replacing "help"
 type HelpFlag struct {
-	Help string
+	Help  string
+	Short string
+	Long  string
 }
 
 // GetID returns the identifier of the argument
@@ -265,11 +859,17 @@ func (f HelpFlag) GetID() string {
 
 // ShortArg returns short flag
 func (f HelpFlag) ShortArg() string {
+	if f.Short != "" {
+		return "-" + f.Short
+	}
 	return "-h"
 }
 
 // LongArg returns full name flag
 func (f HelpFlag) LongArg() string {
+	if f.Long != "" {
+		return "--" + f.Long
+	}
 	return "--help"
 }
 
@@ -285,7 +885,67 @@ func (f HelpFlag) GetHelpStrings() []string {
 	return []string{leftHand, f.Help}
 }
 
+// Arity returns 0 since the flag doesn't consume any value
+func (f HelpFlag) Arity() int {
+	return 0
+}
+
 // Defines the priority of the argument for sorting (also used to determine the argument type)
 func (f HelpFlag) getOrder() int {
 	return orderHelpFlag
 }
+
+/************************************************************/
+
+// VersionFlag argument, added to the parser by SetVersion and stored in the map as "version"
+// whatever its representations
+//  Short    short name replacing "V"
+//  Long     full name replacing "version"
+type VersionFlag struct {
+	Help  string
+	Short string
+	Long  string
+}
+
+// GetID returns the identifier of the argument
+func (f VersionFlag) GetID() string {
+	return "version"
+}
+
+// ShortArg returns short flag
+func (f VersionFlag) ShortArg() string {
+	if f.Short != "" {
+		return "-" + f.Short
+	}
+	return "-V"
+}
+
+// LongArg returns full name flag
+func (f VersionFlag) LongArg() string {
+	if f.Long != "" {
+		return "--" + f.Long
+	}
+	return "--version"
+}
+
+// Represent returns possible argument representations
+func (f VersionFlag) Represent() []string {
+	return []string{f.ShortArg(), f.LongArg()}
+}
+
+// GetHelpStrings returns the two hand sides of the help message
+//  Example: ["-V, --version",  "this is an example of help message"]
+func (f VersionFlag) GetHelpStrings() []string {
+	leftHand := fmt.Sprintf("%s, %s", f.ShortArg(), f.LongArg())
+	return []string{leftHand, f.Help}
+}
+
+// Arity returns 0 since the flag doesn't consume any value
+func (f VersionFlag) Arity() int {
+	return 0
+}
+
+// Defines the priority of the argument for sorting (also used to determine the argument type)
+func (f VersionFlag) getOrder() int {
+	return orderVersionFlag
+}